@@ -0,0 +1,132 @@
+/*
+ * cache.go - Query result caching
+ * Copyright (C) 2016 Lloyd Dilley
+ * http://www.dilley.me/
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package minestat
+
+import "fmt"
+import "sync"
+import "time"
+
+const MAX_CACHE_ENTRIES = 4096 // results kept by CachedQuery() before the oldest is evicted
+
+// Result of a query kept by CachedQuery()
+type cache_entry struct {
+  server *ServerStatus
+  err error
+  queried time.Time
+}
+
+var cache = make(map[string]cache_entry)
+var cache_lock sync.Mutex
+
+/* Returns the result of a query of the address made less than ttl ago, or queries
+   the server and keeps the result. Results are kept per address, port and protocol.
+   A ttl of 0 or less always queries the server, e.g. to refresh a result. At most
+   MAX_CACHE_ENTRIES results are kept, evicting the oldest to make room for another. */
+func CachedQuery(address string, ttl time.Duration, opts ...Option) (*ServerStatus, error) {
+  server := NewServer(address, opts...)
+  key := fmt.Sprintf("%s|%d|%t|%d", server.Address, server.Port, server.Port_set, server.Request_type)
+
+  cache_lock.Lock()
+  entry, ok := cache[key]
+  cache_lock.Unlock()
+  if ok && ttl > 0 && time.Since(entry.queried) < ttl {
+    return entry.server.copy_result(), entry.err
+  }
+
+  err := server.Query()
+  cached := server.copy_result()
+  cache_lock.Lock()
+  if _, ok := cache[key]; !ok && len(cache) >= MAX_CACHE_ENTRIES {
+    evict_oldest()
+  }
+  cache[key] = cache_entry{cached, err, time.Now()}
+  cache_lock.Unlock()
+  return server, err
+}
+
+/* Returns a copy of the result that shares no slices or pointers with it, so that callers
+   cannot change a cached result, e.g. by sorting its player list, nor each other's copies. */
+func (server *ServerStatus) copy_result() *ServerStatus {
+  result := *server
+  if server.Motd_components != nil {
+    result.Motd_components = append([]MotdComponent{}, server.Motd_components...)
+  }
+  if server.Motd_variants != nil {
+    result.Motd_variants = append([]string{}, server.Motd_variants...)
+  }
+  if server.Players.Sample != nil {
+    result.Players.Sample = append([]PlayerSample{}, server.Players.Sample...)
+  }
+  if server.Bedrock_players.Sample != nil {
+    result.Bedrock_players.Sample = append([]PlayerSample{}, server.Bedrock_players.Sample...)
+  }
+  if server.Favicon != nil {
+    result.Favicon = append([]byte{}, server.Favicon...)
+  }
+  if server.Plugins != nil {
+    result.Plugins = append([]string{}, server.Plugins...)
+  }
+  if server.Player_list != nil {
+    result.Player_list = append([]string{}, server.Player_list...)
+  }
+  if server.Mods != nil {
+    result.Mods = append([]Mod{}, server.Mods...)
+  }
+  if server.Fields != nil {
+    result.Fields = append([]string{}, server.Fields...)
+  }
+  if server.Parse_errors != nil {
+    result.Parse_errors = append([]string{}, server.Parse_errors...)
+  }
+  result.Enforces_secure_chat = copy_flag(server.Enforces_secure_chat)
+  result.Previews_chat = copy_flag(server.Previews_chat)
+  result.Prevents_chat_reports = copy_flag(server.Prevents_chat_reports)
+  return &result
+}
+
+func copy_flag(flag *bool) *bool {
+  if flag == nil {
+    return nil
+  }
+  value := *flag
+  return &value
+}
+
+// Removes the result that was queried longest ago. The caller holds cache_lock.
+func evict_oldest() {
+  var oldest string
+  var oldest_time time.Time
+  for key, entry := range cache {
+    if oldest == "" || entry.queried.Before(oldest_time) {
+      oldest, oldest_time = key, entry.queried
+    }
+  }
+  delete(cache, oldest)
+}
+
+/* Forgets all results kept by CachedQuery(). Nothing runs in the background to evict
+   old results, so a long-running service can call this now and then to free results
+   before MAX_CACHE_ENTRIES are kept. There is nothing else to stop on shutdown. */
+func ClearCache() {
+  cache_lock.Lock()
+  cache = make(map[string]cache_entry)
+  cache_lock.Unlock()
+}
@@ -0,0 +1,97 @@
+/*
+ * diff.go - Changes between two query results
+ * Copyright (C) 2016 Lloyd Dilley
+ * http://www.dilley.me/
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package minestat
+
+import "fmt"
+
+// Kind of change between two query results
+type Change_type uint8
+const (
+  CHANGE_ONLINE Change_type = iota // the server came online
+  CHANGE_OFFLINE                   // the server went offline
+  CHANGE_FIELD                     // a field of a server online in both results changed
+)
+
+func (change_type Change_type) String() string {
+  switch change_type {
+  case CHANGE_ONLINE:
+    return "ONLINE"
+  case CHANGE_OFFLINE:
+    return "OFFLINE"
+  case CHANGE_FIELD:
+    return "FIELD"
+  }
+  return fmt.Sprintf("Change_type(%d)", uint8(change_type))
+}
+
+// Difference between two query results as returned by Diff()
+type Change struct {
+  Type Change_type
+  Field string                // name of the changed field, e.g. "Version" or "Players.Online" ("Online" for CHANGE_ONLINE/CHANGE_OFFLINE)
+  Old interface{}             // value in the previous result
+  New interface{}             // value in this result
+}
+
+func (change Change) String() string {
+  if change.Type != CHANGE_FIELD {
+    return change.Type.String()
+  }
+  return fmt.Sprintf("%s: %v -> %v", change.Field, change.Old, change.New)
+}
+
+/* Lists what changed since the previous result prev of the same server, for alerting
+   on changes when polling. Coming online or going offline is a single change, fields
+   are only compared while the server is online in both results. A nil prev has no changes. */
+func (server *ServerStatus) Diff(prev *ServerStatus) []Change {
+  if prev == nil {
+    return nil
+  }
+  if prev.Online != server.Online {
+    change_type := CHANGE_OFFLINE
+    if server.Online {
+      change_type = CHANGE_ONLINE
+    }
+    return []Change{{change_type, "Online", prev.Online, server.Online}}
+  }
+  if !server.Online {
+    return nil
+  }
+
+  var changes []Change
+  compare := func(field string, old interface{}, new interface{}) {
+    if old != new {
+      changes = append(changes, Change{CHANGE_FIELD, field, old, new})
+    }
+  }
+  compare("Edition", prev.Edition, server.Edition)
+  compare("Version", prev.Version, server.Version)
+  compare("Protocol_version", prev.Protocol_version, server.Protocol_version)
+  compare("Motd", prev.Motd, server.Motd)
+  compare("Players.Online", prev.Players.Online, server.Players.Online)
+  compare("Players.Max", prev.Players.Max, server.Players.Max)
+  compare("Game_mode", prev.Game_mode, server.Game_mode)
+  compare("Map", prev.Map, server.Map)
+  compare("Is_modded", prev.Is_modded, server.Is_modded)
+  compare("Maintenance", prev.Maintenance, server.Maintenance)
+  compare("Is_proxy_fallback", prev.Is_proxy_fallback, server.Is_proxy_fallback)
+  compare("Favicon_base64", prev.Favicon_base64, server.Favicon_base64)
+  return changes
+}
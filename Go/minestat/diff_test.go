@@ -0,0 +1,29 @@
+/* Unit tests for diff.go */
+
+package minestat
+
+import "reflect"
+import "testing"
+
+func TestDiff(t *testing.T) {
+  prev := &ServerStatus{Online: true, Version: "1.20.1", Motd: "A Minecraft Server", Players: PlayerInfo{Online: 1, Max: 20}}
+  server := &ServerStatus{Online: true, Version: "1.20.2", Motd: "A Minecraft Server", Players: PlayerInfo{Online: 7, Max: 20}}
+  want := []Change{
+    {CHANGE_FIELD, "Version", "1.20.1", "1.20.2"},
+    {CHANGE_FIELD, "Players.Online", uint32(1), uint32(7)},
+  }
+  if changes := server.Diff(prev); !reflect.DeepEqual(changes, want) {
+    t.Errorf("got %v, want %v", changes, want)
+  }
+
+  offline := &ServerStatus{}
+  if changes := offline.Diff(prev); len(changes) != 1 || changes[0].Type != CHANGE_OFFLINE {
+    t.Errorf("got %v, want a single OFFLINE change", changes)
+  }
+  if changes := prev.Diff(offline); len(changes) != 1 || changes[0].Type != CHANGE_ONLINE {
+    t.Errorf("got %v, want a single ONLINE change", changes)
+  }
+  if changes := server.Diff(server); changes != nil {
+    t.Errorf("got %v, want no changes", changes)
+  }
+}
@@ -0,0 +1,178 @@
+/*
+ * forge.go - Forge mod list parsing
+ * Copyright (C) 2016 Lloyd Dilley
+ * http://www.dilley.me/
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package minestat
+
+import "bytes"
+import "errors"
+import "fmt"
+import "io"
+
+// Mod installed on a Forge server
+type Mod struct {
+  Name string `json:"name"`
+  Version string `json:"version"`
+}
+
+// Forge fields of the 1.7+ status response
+type forge_status struct {
+  // 1.12 and earlier
+  Mod_info *struct {
+    Mod_list []struct {
+      Id string `json:"modid"`
+      Version string `json:"version"`
+    } `json:"modList"`
+  } `json:"modinfo"`
+  // 1.13 and later
+  Forge_data *struct {
+    Mods []struct {
+      Id string `json:"modId"`
+      Marker string `json:"modmarker"`
+    } `json:"mods"`
+    D string `json:"d"` // 1.18.2+ packed mod list, replaces mods
+  } `json:"forgeData"`
+}
+
+// Fills in Mods and Is_modded. A broken mod list is ignored rather than failing the whole query.
+func (server *ServerStatus) parse_forge(status forge_status) {
+  server.Is_modded = status.Mod_info != nil || status.Forge_data != nil
+  if status.Mod_info != nil {
+    for _, mod := range status.Mod_info.Mod_list {
+      server.Mods = append(server.Mods, Mod{mod.Id, mod.Version})
+    }
+  }
+  if status.Forge_data != nil {
+    for _, mod := range status.Forge_data.Mods {
+      server.Mods = append(server.Mods, Mod{mod.Id, mod.Marker})
+    }
+    if status.Forge_data.D != "" {
+      data, err := decode_forge_optimized(status.Forge_data.D, server.Max_response_bytes)
+      var mods []Mod
+      if err == nil {
+        mods, err = decode_forge_mods(data)
+      }
+      if err == nil {
+        server.Mods = append(server.Mods, mods...)
+      } else {
+        server.field_error("mods", err)
+      }
+    }
+  }
+}
+
+/* Unpacks the "d" string of forgeData. Each character carries 15 bits of data,
+   the first two characters hold the length of the data in bytes. The length is sent
+   by the server, so it is checked against the data and max_size before allocating. */
+func decode_forge_optimized(text string, max_size int) ([]byte, error) {
+  chars := []rune(text)
+  if len(chars) < 2 {
+    return nil, errors.New("missing length")
+  }
+  size := int(chars[0] & 0x7FFF) | int(chars[1] & 0x7FFF) << 15
+  if size > (len(chars) - 2) * 15 / 8 || size > max_size {
+    return nil, fmt.Errorf("length of %d bytes does not fit %d characters or the limit of %d bytes", size, len(chars) - 2, max_size)
+  }
+  data := make([]byte, 0, size)
+  buffer := uint32(0)
+  bits := 0
+  for _, char := range chars[2:] {
+    for bits >= 8 {
+      data = append(data, byte(buffer))
+      buffer >>= 8
+      bits -= 8
+    }
+    buffer |= (uint32(char) & 0x7FFF) << uint(bits)
+    bits += 15
+  }
+  for len(data) < size && bits > 0 {
+    data = append(data, byte(buffer))
+    buffer >>= 8
+    bits -= 8
+  }
+  return data, nil
+}
+
+/*
+  Unpacked forgeData mod list:
+  1. Truncated flag as a bool
+  2. Number of mods as an unsigned short
+  3. For each mod:
+    a. VarInt of the channel count << 1 | 1 if the mod is server-side only (has no version)
+    b. Mod ID as a string
+    c. Mod version as a string, unless server-side only
+    d. For each channel: name and version as strings, required on the client as a bool
+  4. Non-mod channels (not needed here)
+  Strings are prefixed with their length as a VarInt.
+*/
+func decode_forge_mods(data []byte) ([]Mod, error) {
+  reader := bytes.NewReader(data)
+  header := make([]byte, 3)
+  _, err := io.ReadFull(reader, header)
+  if err != nil {
+    return nil, err
+  }
+  mod_count := int(header[1]) << 8 | int(header[2])
+  mods := make([]Mod, 0, mod_count)
+  for i := 0; i < mod_count; i++ {
+    flags, err := ReadVarInt(reader)
+    if err != nil {
+      return nil, err
+    }
+    var mod Mod
+    mod.Name, err = read_string(reader)
+    if err != nil {
+      return nil, err
+    }
+    if flags & 1 == 0 {
+      mod.Version, err = read_string(reader)
+      if err != nil {
+        return nil, err
+      }
+    }
+    for channel := 0; channel < flags >> 1; channel++ {
+      _, err = read_string(reader)
+      if err == nil {
+        _, err = read_string(reader)
+      }
+      if err == nil {
+        _, err = reader.ReadByte()
+      }
+      if err != nil {
+        return nil, err
+      }
+    }
+    mods = append(mods, mod)
+  }
+  return mods, nil
+}
+
+// Reads a string prefixed with its length as a VarInt.
+func read_string(reader *bytes.Reader) (string, error) {
+  length, err := ReadVarInt(reader)
+  if err != nil {
+    return "", err
+  }
+  if length < 0 || length > reader.Len() {
+    return "", errors.New("invalid string length")
+  }
+  text := make([]byte, length)
+  reader.Read(text)
+  return string(text), nil
+}
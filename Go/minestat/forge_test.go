@@ -0,0 +1,76 @@
+/* Unit tests for forge.go */
+
+package minestat
+
+import "bytes"
+import "encoding/json"
+import "testing"
+
+// Packs data into a forgeData "d" string the way Forge does.
+func encode_forge_optimized(data []byte) string {
+  chars := []rune{rune(len(data) & 0x7FFF), rune(len(data) >> 15)}
+  buffer := uint32(0)
+  bits := 0
+  for _, value := range data {
+    buffer |= uint32(value) << uint(bits)
+    bits += 8
+    if bits >= 15 {
+      chars = append(chars, rune(buffer & 0x7FFF))
+      buffer >>= 15
+      bits -= 15
+    }
+  }
+  if bits > 0 {
+    chars = append(chars, rune(buffer & 0x7FFF))
+  }
+  return string(chars)
+}
+
+func TestParseForgeOptimized(t *testing.T) {
+  var data bytes.Buffer
+  data.Write([]byte{0x00, 0x00, 0x02}) // not truncated, 2 mods
+  WriteVarInt(&data, 1 << 1)          // 1 channel
+  data.Write([]byte("\x05forge\x0447.1"))
+  data.Write([]byte("\x12forge:tier_sorting\x031.0\x01"))
+  WriteVarInt(&data, 0 << 1 | 1)      // server-side only
+  data.Write([]byte("\x06jei_sr"))
+  WriteVarInt(&data, 0)               // no non-mod channels
+
+  response, _ := json.Marshal(map[string]interface{}{
+    "forgeData": map[string]interface{}{"mods": []string{}, "d": encode_forge_optimized(data.Bytes())},
+  })
+  var status forge_status
+  json.Unmarshal(response, &status)
+  server := NewServer("localhost")
+  server.parse_forge(status)
+  if !server.Is_modded || len(server.Mods) != 2 {
+    t.Fatalf("got %v (modded: %t)", server.Mods, server.Is_modded)
+  }
+  if server.Mods[0] != (Mod{"forge", "47.1"}) || server.Mods[1] != (Mod{"jei_sr", ""}) {
+    t.Errorf("got %v", server.Mods)
+  }
+}
+
+func TestParseForgeModInfo(t *testing.T) {
+  var status forge_status
+  json.Unmarshal([]byte(`{"modinfo": {"type": "FML", "modList": [{"modid": "mcp", "version": "9.42"}]}}`), &status)
+  server := NewServer("localhost")
+  server.parse_forge(status)
+  if !server.Is_modded || len(server.Mods) != 1 || server.Mods[0] != (Mod{"mcp", "9.42"}) {
+    t.Errorf("got %v (modded: %t)", server.Mods, server.Is_modded)
+  }
+}
+
+func TestParseForgeOptimizedHostileLength(t *testing.T) {
+  // Claims about 35 GB of data in a handful of characters.
+  response, _ := json.Marshal(map[string]interface{}{
+    "forgeData": map[string]interface{}{"mods": []string{}, "d": "\U0010FFFF\U0010FFFF\u0001\u0002"},
+  })
+  var status forge_status
+  json.Unmarshal(response, &status)
+  server := NewServer("localhost")
+  server.parse_forge(status)
+  if len(server.Mods) != 0 || len(server.Parse_errors) != 1 {
+    t.Errorf("got mods %v and parse errors %q, want a mods parse error", server.Mods, server.Parse_errors)
+  }
+}
@@ -0,0 +1,73 @@
+/*
+ * http.go - Status as JSON over HTTP
+ * Copyright (C) 2016 Lloyd Dilley
+ * http://www.dilley.me/
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package minestat
+
+import "encoding/json"
+import "net/http"
+import "strconv"
+import "time"
+
+const HANDLER_CACHE_TTL time.Duration = 30 * time.Second // how long Handler() keeps a result before querying again
+
+/* Returns a handler that queries the server given by the "address" and optional "port"
+   URL parameters, e.g. /status?address=mc.example.com&port=25565, and writes the result
+   as JSON. An address without a port may carry one itself or use SRV records. Results
+   are cached for HANDLER_CACHE_TTL with CachedQuery(). The HTTP status is 200 when the
+   server is online, 502 when it is not, 504 when it timed out and 400 for a bad request.
+   Anyone who can reach the handler can make it connect anywhere, so restrict the
+   addresses in front of it where that matters. */
+func Handler(opts ...Option) http.HandlerFunc {
+  return func(writer http.ResponseWriter, request *http.Request) {
+    writer.Header().Set("Content-Type", "application/json")
+    fail := func(message string) {
+      writer.WriteHeader(http.StatusBadRequest)
+      json.NewEncoder(writer).Encode(map[string]string{"error": message})
+    }
+    host, port, port_given, err := split_address(request.URL.Query().Get("address"))
+    if err != nil {
+      fail(err.Error())
+      return
+    }
+    if port_string := request.URL.Query().Get("port"); port_string != "" {
+      parsed_port, err := strconv.ParseUint(port_string, 10, 16)
+      if err != nil || parsed_port == 0 {
+        fail("invalid port " + strconv.Quote(port_string))
+        return
+      }
+      port, port_given = uint16(parsed_port), true
+    }
+    query_opts := opts
+    if port_given {
+      query_opts = append(opts[:len(opts):len(opts)], WithPort(port))
+    }
+
+    server, _ := CachedQuery(host, HANDLER_CACHE_TTL, query_opts...)
+    switch {
+    case server.Online:
+      writer.WriteHeader(http.StatusOK)
+    case server.Connection_status == RETURN_TIMEOUT:
+      writer.WriteHeader(http.StatusGatewayTimeout)
+    default:
+      writer.WriteHeader(http.StatusBadGateway)
+    }
+    json.NewEncoder(writer).Encode(server)
+  }
+}
@@ -0,0 +1,29 @@
+/* Unit tests for http.go */
+
+package minestat
+
+import "encoding/json"
+import "net/http"
+import "net/http/httptest"
+import "testing"
+
+func TestHandler(t *testing.T) {
+  defer ClearCache()
+  handler := Handler(WithProtocol(REQUEST_JSON), WithDialFunc(status_dial_func(TEST_STATUS)))
+
+  recorder := httptest.NewRecorder()
+  handler(recorder, httptest.NewRequest("GET", "/status?address=127.0.0.1&port=25567", nil))
+  var result map[string]interface{}
+  json.Unmarshal(recorder.Body.Bytes(), &result)
+  if recorder.Code != http.StatusOK || result["online"] != true || result["port"] != float64(25567) {
+    t.Errorf("got %d with %s, want 200 with the server online on port 25567", recorder.Code, recorder.Body)
+  }
+
+  for _, url := range []string{"/status", "/status?address=127.0.0.1&port=x"} {
+    recorder = httptest.NewRecorder()
+    handler(recorder, httptest.NewRequest("GET", url, nil))
+    if recorder.Code != http.StatusBadRequest {
+      t.Errorf("got %d for %s, want 400", recorder.Code, url)
+    }
+  }
+}
@@ -0,0 +1,54 @@
+/*
+ * logger.go - Optional logging of the query decision flow
+ * Copyright (C) 2016 Lloyd Dilley
+ * http://www.dilley.me/
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package minestat
+
+/* Receives what happens during a query: the protocols tried, the addresses dialed,
+   the responses received and why they failed to parse. Set one with WithLogger().
+   *log.Logger can be adapted with a pair of methods calling its Printf(). */
+type Logger interface {
+  Debugf(format string, args ...interface{})
+  Warnf(format string, args ...interface{})
+}
+
+// Names of the protocols in log messages
+var request_names = map[uint16]string{
+  REQUEST_LEGACY: "legacy",
+  REQUEST_EXTENDED: "extended",
+  REQUEST_JSON: "JSON",
+  REQUEST_BEDROCK: "Bedrock",
+  REQUEST_QUERY: "Query",
+  REQUEST_BETA: "beta",
+}
+
+/* Logs a debug message, doing nothing when no logger is set. Callers check server.Logger
+   themselves first, so that the arguments are not even built on every query without one. */
+func (server *ServerStatus) debugf(format string, args ...interface{}) {
+  if server.Logger != nil {
+    server.Logger.Debugf(format, args...)
+  }
+}
+
+// Logs a warning, doing nothing when no logger is set.
+func (server *ServerStatus) warnf(format string, args ...interface{}) {
+  if server.Logger != nil {
+    server.Logger.Warnf(format, args...)
+  }
+}
@@ -20,66 +20,2232 @@
 
 package minestat
 
+import "bufio"
+import "bytes"
+import "context"
+import "crypto/rand"
+import "crypto/tls"
+import "encoding/base64"
+import "encoding/binary"
+import "encoding/json"
+import "errors"
+import "fmt"
+import "io"
 import "net"
+import "strconv"
 import "strings"
+import "sync"
+import "syscall"
 import "time"
+import "unicode"
+import "unicode/utf16"
+import "unicode/utf8"
 
 const NUM_FIELDS int = 6
 const DEFAULT_TIMEOUT int = 5 // default TCP timeout in seconds
+const DEFAULT_PORT uint16 = 25565 // default Minecraft Java Edition port
+const DEFAULT_BEDROCK_PORT uint16 = 19132 // default Minecraft Bedrock Edition port
+const NUM_FIELDS_BEDROCK int = 6 // minimum number of fields in a Bedrock pong
+const NUM_FIELDS_BETA int = 3 // number of fields in a beta ping response
+const DEFAULT_MAX_RESPONSE_BYTES int = 2 * 1024 * 1024 // largest response accepted by default (2 MiB)
+
+// Outcome of a query
+type Status_code uint8
+const (
+  RETURN_SUCCESS Status_code = 0  // the server ping completed successfully
+  RETURN_CONNFAIL Status_code = 1 // the server ping failed due to a connection error
+  RETURN_TIMEOUT Status_code = 2  // the server ping failed due to a time out
+  RETURN_UNKNOWN Status_code = 3  // the server ping failed for an unknown reason (unsupported protocol?)
+)
+
+func (status Status_code) String() string {
+  switch status {
+  case RETURN_SUCCESS:
+    return "SUCCESS"
+  case RETURN_CONNFAIL:
+    return "CONNFAIL"
+  case RETURN_TIMEOUT:
+    return "TIMEOUT"
+  case RETURN_UNKNOWN:
+    return "UNKNOWN"
+  }
+  return fmt.Sprintf("Status_code(%d)", uint8(status))
+}
+
+// Reason a connection could not be made
+type Connect_failure uint8
+const (
+  FAILURE_NONE Connect_failure = iota // connected (or the query failed later)
+  FAILURE_DNS                         // the name could not be resolved
+  FAILURE_REFUSED                     // the host is up but nothing listens on the port
+  FAILURE_UNREACHABLE                 // no route to the host or network, it is likely down
+  FAILURE_TIMEOUT                     // no answer at all, the host is down or filtered
+  FAILURE_OTHER                       // any other connection error
+)
+
+/* Error returned (wrapped) by queries that could not connect. Use errors.As() to
+   tell a crashed server (FAILURE_REFUSED) from an offline machine (FAILURE_UNREACHABLE). */
+type ConnectError struct {
+  Reason Connect_failure
+  Err error
+}
+
+func (err *ConnectError) Error() string {
+  return err.Err.Error()
+}
+
+func (err *ConnectError) Unwrap() error {
+  return err.Err
+}
+
+// Player information forwarding expected by servers behind a proxy
+type Forwarding_mode uint8
+const (
+  FORWARDING_NONE Forwarding_mode = iota // plain handshake
+  FORWARDING_BUNGEECORD                  // BungeeCord IP forwarding (bungeecord: true in spigot.yml)
+)
+
+/* Protocols that can be requested with WithProtocol(). Any but REQUEST_NONE and REQUEST_JAVA
+   is strict: only that protocol is tried, with a single connection and no fallback.
+   REQUEST_BEDROCK and REQUEST_QUERY always use UDP, the others always use TCP. */
+const (
+  REQUEST_NONE uint16 = iota  // try every protocol in turn until one succeeds (auto-detection, several connections)
+  REQUEST_LEGACY              // 1.4/1.5 legacy Server List Ping
+  REQUEST_EXTENDED            // 1.6 extended legacy Server List Ping
+  REQUEST_JSON                // 1.7+ JSON Server List Ping
+  REQUEST_BEDROCK             // Bedrock/Pocket Edition unconnected ping
+  REQUEST_QUERY               // Query (GameSpy 4) protocol, needs enable-query=true on the server
+  REQUEST_BETA                // Beta 1.8 to 1.3 Server List Ping
+  REQUEST_JAVA                // try every Java Edition protocol until one succeeds
+)
+
+/* RakNet OFFLINE_MESSAGE_DATA_ID sent in the Bedrock ping. Change it, or use WithRaknetMagic()
+   for a single query, should it ever change or for servers running a RakNet fork. */
+var DEFAULT_RAKNET_MAGIC = []byte{0x00, 0xFF, 0xFF, 0x00, 0xFE, 0xFE, 0xFE, 0xFE, 0xFD, 0xFD, 0xFD, 0xFD, 0x12, 0x34, 0x56, 0x78}
+
+/* Protocols tried in turn by REQUEST_NONE: the 1.7+ JSON protocol first, falling back to
+   the older pings for older servers, and Bedrock last. REQUEST_JAVA leaves Bedrock out. */
+var DEFAULT_PROTOCOL_ORDER = []uint16{REQUEST_JSON, REQUEST_EXTENDED, REQUEST_LEGACY, REQUEST_BETA, REQUEST_BEDROCK}
+
+/* Package globals filled in by Init(). These are shared by every caller, so use
+   NewServer() and Query() instead when checking several servers concurrently. */
 var Address string
 var Port string
 var Online bool               // online or offline?
+var Edition string            // "Java" or "Bedrock"
+var Bedrock_edition string    // "MCPE" or "MCEE" (Education Edition), Bedrock only
 var Version string            // server version
+var Version_name string       // version number alone, e.g. "1.20.12", Bedrock only
+var Protocol_version int      // protocol version number (-1 if unknown)
 var Motd string               // message of the day
+var Motd_clean string         // message of the day without formatting codes
+var Motd_safe string          // Motd_clean without control characters and escape sequences, safe to print to a terminal
+var Motd_length int           // length of the message of the day in bytes, formatting codes included
+var Motd_truncated bool       // message of the day ends in a "§" without its code, likely cut off by the server
+var Motd_components []MotdComponent // message of the day split into runs of text with their colors and styles
 var Current_players string    // current number of players online
 var Max_players string        // maximum player capacity
+var Players []PlayerSample    // sample of online players, 1.7+ only (often empty or randomized)
 var Latency time.Duration     // ping time to server in milliseconds
+var Protocol string           // protocol used to query the server
+var Connection_status = RETURN_UNKNOWN // outcome of the last Init() (RETURN_UNKNOWN before the first one)
+var Favicon []byte            // server icon (PNG), 1.7+ only
+var Favicon_base64 string     // server icon as base64 without the data URI prefix, 1.7+ only
+var Game_mode string          // game mode, Bedrock only
+var Game_mode_id int          // numeric game mode, Bedrock only (-1 if unknown)
+var Server_id string          // unique server ID, Bedrock only
+var Server_guid uint64        // RakNet GUID of the server, the same for every address it answers on, Bedrock only
+var Timestamp_mismatch bool   // the pong did not echo the time sent in the ping, Bedrock only
+var Port_ipv4 uint16          // advertised IPv4 port, Bedrock only (0 if unknown)
+var Port_ipv6 uint16          // advertised IPv6 port, Bedrock only (0 if unknown)
+var Map string                // world name, Query only
+var Plugins []string          // installed plugins, Query only
+var Player_list []string      // names of all online players, Query only
+var Mods []Mod                // installed mods, Forge only
+var Is_modded bool            // Forge server?
+var Enforces_secure_chat *bool // requires signed chat messages, 1.19.1+ only (nil if not sent)
+var Previews_chat *bool       // previews chat messages, 1.19 to 1.19.2 only (nil if not sent)
+var Prevents_chat_reports *bool // advertised by the No Chat Reports mod (nil if not sent)
+var Version_mismatch bool     // advertises a fake version to show as incompatible, 1.7+ only (heuristic)
+var Maintenance bool          // appears to be in maintenance or whitelist mode, 1.7+ only (heuristic)
+var Is_proxy_fallback bool    // answered by a proxy instead of the backend server, 1.7+ only (heuristic)
+var Raw_json json.RawMessage  // status response as sent by the server, for fields not parsed here, 1.7+ only
+var Capture_fields bool       // keep the raw split response fields in Fields (debugging aid)
+var Request_type uint16       // protocol Init() queries (REQUEST_NONE tries each of them)
+
+/* MOTDs (case-insensitive, without formatting codes) that proxies answer with when the
+   backend server is down or the hostname is unknown. Proxies that are working normally
+   also report e.g. "BungeeCord 1.20" as the version, so the version is not a sign of it.
+   Add the fallback MOTD of your own network to recognize it as well. */
+var Proxy_fallback_motds = []string{
+  "Could not connect to a default or fallback server",
+  "Server not found",           // TCPShield
+  "Unknown host",
+  "Invalid hostname",
+}
+
+var Fields []string           // raw delimiter-split response fields (only set when Capture_fields is true)
+
+// Status of a single server. Create one with NewServer() and fill it in with Query().
+type ServerStatus struct {
+  Address string
+  Port uint16
+  Port_set bool               // port given explicitly (otherwise a _minecraft._tcp SRV record may override it)
+  Timeout time.Duration       // TCP timeout
+  Read_timeout time.Duration  // timeout for the server to reply once connected (0 uses Timeout)
+  Request_type uint16         // protocol to query (REQUEST_NONE tries all of them)
+  Protocol_order []uint16     // protocols REQUEST_NONE and REQUEST_JAVA try, in order (nil for DEFAULT_PROTOCOL_ORDER)
+  Dialer *net.Dialer          // dialer used to connect (nil for a plain net.Dialer with Timeout)
+  Resolver *net.Resolver      // resolver for SRV records and addresses (nil for net.DefaultResolver)
+  Local_addr net.Addr         // source address to connect from (nil for the system's choice)
+  Dial_func func(ctx context.Context, network string, address string) (net.Conn, error) // replaces Dialer when set, e.g. for proxies
+  Tls_config *tls.Config      // wraps TCP connections in TLS when set, for servers behind a TLS tunnel
+  Capture_fields bool         // keep the raw split response fields in Fields (debugging aid)
+  Ping bool                   // send the optional ping after the 1.7+ status response to measure Ping_latency
+  Handshake_protocol int      // protocol version sent in the 1.7+ handshake (-1 by default)
+  Handshake_host string       // host name sent in the handshake instead of Address (empty for Address)
+  Fml_marker int              // FML version marker appended to the handshake address (0 for none)
+  Forwarding Forwarding_mode  // player information forwarding added to the handshake
+  Forwarding_ip string        // client IP to forward
+  Forwarding_uuid string      // player UUID to forward
+  Max_response_bytes int      // largest response accepted, longer ones fail with RETURN_UNKNOWN
+  Client_guid uint64          // client GUID sent in the Bedrock ping (0 for a random one per query)
+  Raknet_magic []byte         // RakNet magic sent in the Bedrock ping, 16 bytes (nil for DEFAULT_RAKNET_MAGIC)
+  Retries int                 // number of times a timed out query is retried
+  Retry_backoff time.Duration // delay before each retry
+  Logger Logger               // receives debug messages and warnings (nil for none)
+  Motd_samples int            // number of times to query the MOTD to detect a rotating one (0 for once)
+  Online bool                 // online or offline?
+  Edition string              // "Java" or "Bedrock"
+  Bedrock_edition string      // "MCPE" or "MCEE" (Education Edition), Bedrock only
+  Version string              // server version
+  Version_name string         // version number alone, e.g. "1.20.12", Bedrock only
+  Protocol_version int        // protocol version number (-1 if unknown)
+  Motd string                 // message of the day
+  Motd_clean string           // message of the day without formatting codes
+  Motd_safe string            // Motd_clean without control characters and escape sequences, safe to print to a terminal
+  Motd_length int             // length of the message of the day in bytes, formatting codes included
+  Motd_truncated bool         // message of the day ends in a "§" without its code, likely cut off by the server
+  Motd_components []MotdComponent // message of the day split into runs of text with their colors and styles
+  Motd_dynamic bool           // MOTD changed between queries, e.g. rotated by ServerListPlus (only set with WithMotdSamples())
+  Motd_variants []string      // distinct MOTDs seen, in the order first seen (only set with WithMotdSamples())
+  Players PlayerInfo          // current and maximum number of players
+  Latency time.Duration       // ping time to server with full precision
+  Timings Timings             // where the time of the last connection went
+  Ping_latency time.Duration  // round trip of the 1.7+ ping packet, only set with WithPing() (0 if not measured)
+  Protocol string             // protocol used to query the server
+  Connection_status Status_code // outcome of the last query
+  Connect_failure Connect_failure // reason the last query could not connect (FAILURE_NONE if it did)
+  Attempts int                // number of attempts the last query took
+  Favicon []byte              // server icon (PNG), 1.7+ only
+  Favicon_base64 string       // server icon as base64 without the data URI prefix, 1.7+ only
+  Game_mode string            // game mode, Bedrock only
+  Game_mode_id int            // numeric game mode, Bedrock only (-1 if unknown)
+  Server_id string            // unique server ID, Bedrock only
+  Server_guid uint64          // RakNet GUID of the server, the same for every address it answers on, Bedrock only
+  Timestamp_mismatch bool     // the pong did not echo the time sent in the ping, Bedrock only
+  Port_ipv4 uint16            // advertised IPv4 port, Bedrock only (0 if unknown)
+  Port_ipv6 uint16            // advertised IPv6 port, Bedrock only (0 if unknown)
+  Map string                  // world name, Query only
+  Plugins []string            // installed plugins, Query only
+  Player_list []string        // names of all online players, Query only
+  Mods []Mod                  // installed mods, Forge only
+  Is_modded bool              // Forge server?
+  Enforces_secure_chat *bool  // requires signed chat messages, 1.19.1+ only (nil if not sent)
+  Previews_chat *bool         // previews chat messages, 1.19 to 1.19.2 only (nil if not sent)
+  Prevents_chat_reports *bool // advertised by the No Chat Reports mod (nil if not sent)
+  Version_mismatch bool       // advertises a fake version to show as incompatible, 1.7+ only (heuristic)
+  Maintenance bool            // appears to be in maintenance or whitelist mode, 1.7+ only (heuristic)
+  Is_proxy_fallback bool      // answered by a proxy instead of the backend server, 1.7+ only (heuristic)
+  Is_hybrid bool              // answers both Java and Bedrock Edition clients, e.g. with Geyser (only set by QueryHybrid())
+  Bedrock_players PlayerInfo  // player counts reported to Bedrock Edition clients (only set by QueryHybrid())
+  Fields []string             // raw delimiter-split response fields (only set when Capture_fields is true)
+  Parse_errors []string       // fields that could not be parsed, including those of protocols tried before, as "field: error"
+  Raw_json json.RawMessage    // status response as sent by the server, for fields not parsed here, 1.7+ only
+  Resolved_address string     // address the last connection went to (after SRV lookup)
+  Resolved_port uint16        // port the last connection went to (after SRV lookup)
+  dial_address string         // address to connect to (after SRV lookup)
+  dial_port uint16            // port to connect to (after SRV lookup)
+  bedrock_address string      // address to send the Bedrock ping to (after SRV lookup)
+  bedrock_port uint16         // port to send the Bedrock ping to (after SRV lookup)
+  conn net.Conn               // connection given to ParseFromConn() instead of dialing
+  answered_request uint16     // protocol that answered the last query
+  srv_lookup time.Duration    // time taken by the SRV lookups of the last query
+  any_transport bool          // apply options only where they fit, for queries combining protocols
+}
+
+// Number of players, shaped like the "players" object of the 1.7+ status response
+type PlayerInfo struct {
+  Online uint32 `json:"online"`                   // current number of players online
+  Max uint32 `json:"max"`                         // maximum player capacity
+  Sample []PlayerSample `json:"sample,omitempty"` // sample of online players, 1.7+ only (often empty or randomized)
+}
+
+// Online player as listed in the 1.7+ status response
+type PlayerSample struct {
+  Name string `json:"name"`
+  UUID string `json:"id"`
+}
+
+/* Serializes the query results for web APIs and status pages, e.g.
+   {"address": "...", "port": 25565, "online": true, "players": {"online": 3, "max": 20}, ...}
+   Fields that only some protocols provide are omitted when empty. */
+func (server ServerStatus) MarshalJSON() ([]byte, error) {
+  type players struct {
+    Online uint32 `json:"online"`
+    Max uint32 `json:"max"`
+    Sample []PlayerSample `json:"sample,omitempty"`
+    List []string `json:"list,omitempty"`
+  }
+  result := struct {
+    Address string `json:"address"`
+    Port uint16 `json:"port"`
+    Online bool `json:"online"`
+    Connection_status Status_code `json:"connection_status"`
+    Edition string `json:"edition,omitempty"`
+    Bedrock_edition string `json:"bedrock_edition,omitempty"`
+    Version string `json:"version"`
+    Version_name string `json:"version_name,omitempty"`
+    Protocol_version int `json:"protocol_version"`
+    Motd string `json:"motd"`
+    Motd_clean string `json:"motd_clean"`
+    Motd_components []MotdComponent `json:"motd_components,omitempty"`
+    Motd_truncated bool `json:"motd_truncated,omitempty"`
+    Motd_dynamic bool `json:"motd_dynamic,omitempty"`
+    Motd_variants []string `json:"motd_variants,omitempty"`
+    Players players `json:"players"`
+    Latency_ms int64 `json:"latency_ms"`
+    Protocol string `json:"protocol"`
+    Favicon string `json:"favicon,omitempty"`
+    Game_mode string `json:"game_mode,omitempty"`
+    Server_id string `json:"server_id,omitempty"`
+    Server_guid uint64 `json:"server_guid,omitempty,string"` // a string, since JavaScript numbers cannot hold every 64-bit value
+    Map string `json:"map,omitempty"`
+    Plugins []string `json:"plugins,omitempty"`
+    Mods []Mod `json:"mods,omitempty"`
+    Enforces_secure_chat *bool `json:"enforces_secure_chat,omitempty"`
+    Previews_chat *bool `json:"previews_chat,omitempty"`
+    Prevents_chat_reports *bool `json:"prevents_chat_reports,omitempty"`
+    Is_hybrid bool `json:"is_hybrid,omitempty"`
+    Bedrock_players *PlayerInfo `json:"bedrock_players,omitempty"`
+  }{
+    Address: server.Address,
+    Port: server.Port,
+    Online: server.Online,
+    Connection_status: server.Connection_status,
+    Edition: server.Edition,
+    Bedrock_edition: server.Bedrock_edition,
+    Version: server.Version,
+    Version_name: server.Version_name,
+    Protocol_version: server.Protocol_version,
+    Motd: server.Motd,
+    Motd_clean: server.Motd_clean,
+    Motd_components: server.Motd_components,
+    Motd_truncated: server.Motd_truncated,
+    Motd_dynamic: server.Motd_dynamic,
+    Motd_variants: server.Motd_variants,
+    Players: players{server.Players.Online, server.Players.Max, server.Players.Sample, server.Player_list},
+    Latency_ms: server.Latency.Milliseconds(),
+    Protocol: server.Protocol,
+    Game_mode: server.Game_mode,
+    Server_id: server.Server_id,
+    Server_guid: server.Server_guid,
+    Map: server.Map,
+    Plugins: server.Plugins,
+    Mods: server.Mods,
+    Enforces_secure_chat: server.Enforces_secure_chat,
+    Previews_chat: server.Previews_chat,
+    Prevents_chat_reports: server.Prevents_chat_reports,
+  }
+  if server.Is_hybrid {
+    result.Is_hybrid = true
+    result.Bedrock_players = &server.Bedrock_players
+  }
+  if server.Favicon_base64 != "" {
+    result.Favicon = "data:image/png;base64," + server.Favicon_base64
+  }
+  return json.Marshal(result)
+}
+
+// Configures a ServerStatus created by NewServer().
+type Option func(*ServerStatus)
+
+/* Sets the server port. Without it, a _minecraft._tcp SRV record is looked up
+   like the vanilla client does, falling back to port 25565. */
+func WithPort(port uint16) Option {
+  return func(server *ServerStatus) {
+    server.Port = port
+    server.Port_set = true
+  }
+}
+
+// Sets the TCP timeout (default: 5 seconds).
+func WithTimeout(timeout time.Duration) Option {
+  return func(server *ServerStatus) {
+    server.Timeout = timeout
+  }
+}
+
+// Sets how long to wait for the server to reply once connected (default: the TCP timeout).
+func WithReadTimeout(read_timeout time.Duration) Option {
+  return func(server *ServerStatus) {
+    server.Read_timeout = read_timeout
+  }
+}
+
+// Keeps the raw split response fields in Fields when enabled (default: off).
+func WithCaptureFields(capture_fields bool) Option {
+  return func(server *ServerStatus) {
+    server.Capture_fields = capture_fields
+  }
+}
+
+/* Sends the optional ping after the 1.7+ status response and sets Ping_latency to its round
+   trip (default: false). A server hanging up instead of answering the ping does not fail the query. */
+func WithPing(ping bool) Option {
+  return func(server *ServerStatus) {
+    server.Ping = ping
+  }
+}
+
+/* Queries only the given protocol instead of trying each of them, which takes a single
+   connection rather than up to five when the server is old or offline (default: REQUEST_NONE). */
+func WithProtocol(request_type uint16) Option {
+  return func(server *ServerStatus) {
+    server.Request_type = request_type
+  }
+}
+
+/* Sets the protocols auto-detection tries and their order (default: DEFAULT_PROTOCOL_ORDER),
+   e.g. to try the beta ping first for a fleet of old servers or to leave protocols out. */
+func WithProtocolOrder(order []uint16) Option {
+  return func(server *ServerStatus) {
+    server.Protocol_order = order
+  }
+}
+
+/* Connects from the given source address, e.g. a *net.TCPAddr of the interface allowed
+   through the server's firewall (default: chosen by the system). A port of 0 picks any port.
+   It overrides the LocalAddr of WithDialer() and is ignored with WithDialFunc(). */
+func WithLocalAddr(local_addr net.Addr) Option {
+  return func(server *ServerStatus) {
+    server.Local_addr = local_addr
+  }
+}
 
-func Init(given_address string, given_port string, optional_timeout ...int) {
+// Connects with the given dialer, e.g. to set a local address or keep-alive. Its Timeout defaults to the query timeout.
+func WithDialer(dialer *net.Dialer) Option {
+  return func(server *ServerStatus) {
+    server.Dialer = dialer
+  }
+}
+
+/* Resolves SRV records and addresses with the given resolver, e.g. one whose Dial
+   function points at 1.1.1.1 or a DNS-over-HTTPS proxy (default: net.DefaultResolver). */
+func WithResolver(resolver *net.Resolver) Option {
+  return func(server *ServerStatus) {
+    server.Resolver = resolver
+  }
+}
+
+/* Connects with the given function instead of a net.Dialer, e.g. to go through a SOCKS5 proxy
+   with golang.org/x/net/proxy or to inject connections in tests. It is called with "tcp" or "udp"
+   and an unresolved "host:port" address, and takes precedence over WithDialer(). */
+func WithDialFunc(dial_func func(ctx context.Context, network string, address string) (net.Conn, error)) Option {
+  return func(server *ServerStatus) {
+    server.Dial_func = dial_func
+  }
+}
+
+/* Wraps the TCP connection in TLS with the given configuration, for servers exposed through
+   a TLS tunnel such as stunnel (default: no TLS). The server name defaults to the address
+   given. Bedrock and Query use UDP and are never wrapped. */
+func WithTLS(config *tls.Config) Option {
+  return func(server *ServerStatus) {
+    server.Tls_config = config
+  }
+}
+
+/* Sets the protocol version sent in the 1.7+ handshake, e.g. 763 to look like a 1.20.1 client
+   to anti-bot plugins that reject implausible clients. The default of -1 is what clients send
+   for status pings when they do not know the server version yet. */
+func WithProtocolVersion(protocol_version int) Option {
+  return func(server *ServerStatus) {
+    server.Handshake_protocol = protocol_version
+  }
+}
+
+/* Sends the given host name in the 1.4+ handshake instead of the address that is
+   connected to (default: Address). Proxies route by this name, so it picks the virtual
+   host to query, e.g. connecting to 1.2.3.4 while presenting "play.example.com". */
+func WithHandshakeHost(host string) Option {
+  return func(server *ServerStatus) {
+    server.Handshake_host = host
+  }
+}
+
+/* Appends the FML marker of a Forge client to the handshake address: 1 for 1.7 to 1.12,
+   2 for 1.13 to 1.17 and 3 for 1.18+ (default: 0, no marker). Some modded servers
+   and proxies only show their real status to Forge clients. */
+func WithFMLMarker(fml_version int) Option {
+  return func(server *ServerStatus) {
+    server.Fml_marker = fml_version
+  }
+}
+
+/* Sets the largest response accepted from the server (default: 2 MiB), so that a hostile
+   server cannot make the query allocate whatever size it claims. */
+func WithMaxResponseBytes(max_response_bytes int) Option {
+  return func(server *ServerStatus) {
+    server.Max_response_bytes = max_response_bytes
+  }
+}
+
+// Sends the given client GUID in the Bedrock ping (default: a random one per query).
+func WithClientGUID(client_guid uint64) Option {
+  return func(server *ServerStatus) {
+    server.Client_guid = client_guid
+  }
+}
+
+// Sends the given 16-byte RakNet magic in the Bedrock ping (default: DEFAULT_RAKNET_MAGIC).
+func WithRaknetMagic(magic []byte) Option {
+  return func(server *ServerStatus) {
+    server.Raknet_magic = magic
+  }
+}
+
+/* Adds player information forwarding to the 1.7+ handshake like a proxy does, to query
+   a backend server behind the proxy directly (default: FORWARDING_NONE). Velocity modern
+   forwarding only happens during login, so status requests need no forwarding for it. */
+func WithForwarding(mode Forwarding_mode, ip string, uuid string) Option {
+  return func(server *ServerStatus) {
+    server.Forwarding = mode
+    server.Forwarding_ip = ip
+    server.Forwarding_uuid = uuid
+  }
+}
+
+/* Retries a query that timed out up to retries times, waiting backoff before each
+   attempt (default: no retries). Refused connections are not retried. */
+func WithRetries(retries int, backoff time.Duration) Option {
+  return func(server *ServerStatus) {
+    server.Retries = retries
+    server.Retry_backoff = backoff
+  }
+}
+
+/* Sends what happens during the query to logger, e.g. which protocols were tried and
+   why they failed (default: nothing is logged). */
+func WithLogger(logger Logger) Option {
+  return func(server *ServerStatus) {
+    server.Logger = logger
+  }
+}
+
+/* Queries the MOTD count times in total with the protocol that answered, and sets
+   Motd_dynamic when it changes, e.g. on servers rotating it with ServerListPlus
+   (default: the MOTD is queried once). Each extra query is a full request. */
+func WithMotdSamples(count int) Option {
+  return func(server *ServerStatus) {
+    server.Motd_samples = count
+  }
+}
+
+/* Splits a "host:port" or "host" address as found in server lists, with IPv6 addresses
+   in brackets when followed by a port. The port defaults to 25565. */
+func ParseAddress(address string) (host string, port uint16, err error) {
+  host, port, port_given, err := split_address(address)
+  if err == nil && !port_given {
+    port = DEFAULT_PORT
+  }
+  return host, port, err
+}
+
+// Like ParseAddress() for Bedrock servers, with the port defaulting to 19132.
+func ParseBedrockAddress(address string) (host string, port uint16, err error) {
+  host, port, port_given, err := split_address(address)
+  if err == nil && !port_given {
+    port = DEFAULT_BEDROCK_PORT
+  }
+  return host, port, err
+}
+
+func split_address(address string) (host string, port uint16, port_given bool, err error) {
+  address = strings.TrimSpace(address)
+  host = address
+  port_string := ""
+  // A bare IPv6 address has several colons and no port.
+  if strings.HasPrefix(address, "[") || strings.Count(address, ":") == 1 {
+    if strings.HasSuffix(address, "]") {
+      host = address[1:len(address) - 1]
+    } else {
+      host, port_string, err = net.SplitHostPort(address)
+      if err != nil {
+        return "", 0, false, fmt.Errorf("invalid address %q: %w", address, err)
+      }
+      port_given = true
+    }
+  }
+  if host == "" || strings.ContainsAny(host, " \t/[]@") {
+    return "", 0, false, fmt.Errorf("invalid address %q", address)
+  }
+  if strings.Contains(host, ":") && net.ParseIP(host) == nil {
+    return "", 0, false, fmt.Errorf("invalid IPv6 address %q", host)
+  }
+  if port_given {
+    parsed_port, err := strconv.ParseUint(port_string, 10, 16)
+    if err != nil || parsed_port == 0 {
+      return "", 0, false, fmt.Errorf("invalid port %q", port_string)
+    }
+    port = uint16(parsed_port)
+  }
+  return host, port, port_given, nil
+}
+
+// Creates a ServerStatus for the given address. Nothing is sent until Query() is called.
+func NewServer(address string, opts ...Option) *ServerStatus {
+  server := &ServerStatus{
+    // JoinHostPort adds the brackets around IPv6 literals when dialing.
+    Address: strings.TrimSuffix(strings.TrimPrefix(address, "["), "]"),
+    Port: DEFAULT_PORT,
+    Timeout: time.Duration(DEFAULT_TIMEOUT) * time.Second,
+    Protocol_version: -1,
+    Handshake_protocol: -1,
+    Max_response_bytes: DEFAULT_MAX_RESPONSE_BYTES,
+    Game_mode_id: -1,
+    Connection_status: RETURN_UNKNOWN,
+  }
+  for _, opt := range opts {
+    opt(server)
+  }
+  return server
+}
+
+/* Queries the server over a connection opened by the caller, e.g. one end of net.Pipe()
+   in tests or a connection to reuse afterwards, which is left open. The protocol must be
+   given (REQUEST_JSON, REQUEST_LEGACY, ...) since a connection only allows one attempt,
+   so neither REQUEST_NONE nor REQUEST_JAVA, which try several protocols, is accepted.
+   UDP protocols need a net.Conn from net.DialUDP() or similar. */
+func ParseFromConn(conn net.Conn, protocol uint16, opts ...Option) (*ServerStatus, error) {
+  address, port := "", uint16(0)
+  if host, port_string, err := net.SplitHostPort(conn.RemoteAddr().String()); err == nil {
+    parsed_port, _ := strconv.ParseUint(port_string, 10, 16)
+    address, port = host, uint16(parsed_port)
+  }
+  server := NewServer(address, append(opts[:len(opts):len(opts)], WithPort(port), WithProtocol(protocol))...)
+  if protocol == REQUEST_NONE || protocol == REQUEST_JAVA {
+    return server, errors.New("a single protocol is required to query over a given connection")
+  }
+  server.conn = conn
+  err := server.Query()
+  server.conn = nil
+  // Hand the connection back without the deadline of the query.
+  conn.SetDeadline(time.Time{})
+  return server, err
+}
+
+/* Time spent in each step of the last connection of a query, to tell a slow DNS server
+   from a slow network or a slow Minecraft server. */
+type Timings struct {
+  DNS time.Duration           // SRV and address lookups (0 for an IP address)
+  Connect time.Duration       // TCP connect, the same as Latency over TCP (0 over UDP and for ParseFromConn())
+  Ping time.Duration          // from sending the first request to the first byte of the response
+}
+
+// Connection that fills in Timings.Ping.
+type timing_conn struct {
+  net.Conn
+  timings *Timings
+  sent time.Time
+  received bool
+}
+
+func (conn *timing_conn) Write(data []byte) (int, error) {
+  if conn.sent.IsZero() {
+    conn.sent = time.Now()
+  }
+  return conn.Conn.Write(data)
+}
+
+func (conn *timing_conn) Read(data []byte) (int, error) {
+  length, err := conn.Conn.Read(data)
+  if length > 0 && !conn.received && !conn.sent.IsZero() {
+    conn.timings.Ping = time.Since(conn.sent)
+    conn.received = true
+  }
+  return length, err
+}
+
+// Connection passed to ParseFromConn(), which stays open for the caller.
+type borrowed_conn struct {
+  net.Conn
+}
+
+func (conn borrowed_conn) Close() error {
+  return nil
+}
+
+/* Queries the server, trying each supported protocol until one of them succeeds,
+   or only the protocol set with WithProtocol(). */
+func (server *ServerStatus) Query() error {
+  return server.QueryContext(context.Background())
+}
+
+/* Same as Query(), but gives up as soon as ctx is canceled. A deadline on ctx
+   bounds the whole query, including reads from a server that stalls. */
+func (server *ServerStatus) QueryContext(ctx context.Context) error {
+  server.reset_results()
+  server.resolve_srv(ctx)
+  var retval Status_code
+  var err error
+  for {
+    server.Attempts++
+    retval, err = server.query_protocols(ctx)
+    // Only a timeout may be transient, a refused connection or bad response will not change.
+    if retval != RETURN_TIMEOUT || server.Attempts > server.Retries || ctx.Err() != nil {
+      break
+    }
+    if server.Logger != nil {
+      server.debugf("%s:%d: attempt %d timed out, retrying in %s", server.Address, server.Port, server.Attempts, server.Retry_backoff)
+    }
+    select {
+    case <-time.After(server.Retry_backoff):
+    case <-ctx.Done():
+    }
+  }
+  server.Online = retval == RETURN_SUCCESS
+  server.Connection_status = retval
+  if server.Online && server.Edition == "" {
+    server.Edition = "Java"
+  }
+  server.Connect_failure = FAILURE_NONE
+  var connect_error *ConnectError
+  if errors.As(err, &connect_error) {
+    server.Connect_failure = connect_error.Reason
+  }
+  if server.Online {
+    server.Motd_clean = StripFormatting(server.Motd)
+    server.Motd_safe = SanitizeForTerminal(server.Motd_clean)
+    server.Motd_length = len(server.Motd)
+    // A server cutting the MOTD to length may split a formatting code from its "§".
+    server.Motd_truncated = strings.HasSuffix(server.Motd, "§")
+    if server.Motd_components == nil {
+      server.Motd_components = legacy_components(server.Motd, MotdComponent{})
+    }
+    if server.Motd_samples > 1 {
+      server.sample_motds(ctx)
+    }
+  }
+  return err
+}
+
+/* Queries the server again with the protocol that answered and collects the distinct MOTDs.
+   Failed queries are skipped, since a single lost response says nothing about the MOTD. */
+func (server *ServerStatus) sample_motds(ctx context.Context) {
+  server.Motd_variants = []string{server.Motd}
+  // A connection given to ParseFromConn() only answers once.
+  if server.conn != nil {
+    return
+  }
+  for i := 1; i < server.Motd_samples && ctx.Err() == nil; i++ {
+    sample := *server
+    sample.Parse_errors = nil
+    retval, _ := sample.request(ctx, server.answered_request)
+    if retval != RETURN_SUCCESS {
+      continue
+    }
+    seen := false
+    for _, motd := range server.Motd_variants {
+      seen = seen || motd == sample.Motd
+    }
+    if !seen {
+      server.Motd_variants = append(server.Motd_variants, sample.Motd)
+    }
+  }
+  server.Motd_dynamic = len(server.Motd_variants) > 1
+}
+
+/* Clears the results of a previous query, so that a server queried again does not keep
+   e.g. the version of an earlier answer once offline, or list its mods twice. */
+func (server *ServerStatus) reset_results() {
+  fresh := NewServer(server.Address)
+  fresh.Port = server.Port
+  fresh.Port_set = server.Port_set
+  fresh.Timeout = server.Timeout
+  fresh.Read_timeout = server.Read_timeout
+  fresh.Request_type = server.Request_type
+  fresh.Protocol_order = server.Protocol_order
+  fresh.Dialer = server.Dialer
+  fresh.Resolver = server.Resolver
+  fresh.Local_addr = server.Local_addr
+  fresh.Dial_func = server.Dial_func
+  fresh.Tls_config = server.Tls_config
+  fresh.Capture_fields = server.Capture_fields
+  fresh.Ping = server.Ping
+  fresh.Handshake_protocol = server.Handshake_protocol
+  fresh.Handshake_host = server.Handshake_host
+  fresh.Fml_marker = server.Fml_marker
+  fresh.Forwarding = server.Forwarding
+  fresh.Forwarding_ip = server.Forwarding_ip
+  fresh.Forwarding_uuid = server.Forwarding_uuid
+  fresh.Max_response_bytes = server.Max_response_bytes
+  fresh.Client_guid = server.Client_guid
+  fresh.Raknet_magic = server.Raknet_magic
+  fresh.Retries = server.Retries
+  fresh.Retry_backoff = server.Retry_backoff
+  fresh.Logger = server.Logger
+  fresh.Motd_samples = server.Motd_samples
+  fresh.conn = server.conn
+  fresh.any_transport = server.any_transport
+  *server = *fresh
+}
+
+// Queries the configured protocol, or each protocol in turn until one succeeds.
+func (server *ServerStatus) query_protocols(ctx context.Context) (retval Status_code, err error) {
+  err = server.check_transport()
+  if err != nil {
+    return RETURN_UNKNOWN, err
+  }
+  if server.Request_type != REQUEST_NONE && server.Request_type != REQUEST_JAVA {
+    return server.request(ctx, server.Request_type)
+  }
+  order := server.Protocol_order
+  if order == nil {
+    order = DEFAULT_PROTOCOL_ORDER
+  }
+  retval, err = RETURN_UNKNOWN, errors.New("no protocol to try")
+  // The first failure to connect over TCP, which says more about the server than a UDP timeout
+  var tcp_retval Status_code
+  var tcp_err error
+  for _, request_type := range order {
+    udp := request_type == REQUEST_BEDROCK || request_type == REQUEST_QUERY
+    if request_type == REQUEST_BEDROCK && server.Request_type == REQUEST_JAVA {
+      continue
+    }
+    // A refused or timed out connection will not succeed with a different protocol over TCP
+    // either, but Bedrock and Query listen on UDP, so try them even then.
+    if tcp_err != nil && !udp {
+      continue
+    }
+    retval, err = server.request(ctx, request_type)
+    if retval == RETURN_SUCCESS || ctx.Err() != nil {
+      break
+    }
+    var connect_failure *ConnectError
+    if tcp_err == nil && !udp && errors.As(err, &connect_failure) {
+      tcp_retval, tcp_err = retval, err
+    }
+  }
+  if retval != RETURN_SUCCESS && tcp_err != nil {
+    return tcp_retval, tcp_err
+  }
+  return retval, err
+}
+
+/* Queries the address as a Java Edition server over TCP and as a Bedrock Edition server
+   over UDP at the same time, and returns the first result that is online. Check Edition
+   to see which one answered. When neither does, the Java Edition result is returned.
+   As with QueryHybrid(), a port given with WithPort() only applies to Java Edition. */
+func QueryAnyEdition(ctx context.Context, address string, opts ...Option) (*ServerStatus, error) {
+  ctx, cancel := context.WithCancel(ctx)
+  defer cancel()
+  type result struct {
+    server *ServerStatus
+    err error
+  }
+  java_result := make(chan result, 1)
+  bedrock_result := make(chan result, 1)
+  for request_type, results := range map[uint16]chan result{REQUEST_JAVA: java_result, REQUEST_BEDROCK: bedrock_result} {
+    go func(request_type uint16, results chan result) {
+      server := NewServer(address, opts...)
+      server.Request_type = request_type
+      server.any_transport = true
+      if request_type == REQUEST_BEDROCK {
+        // Bedrock Edition listens on its own port rather than the Java Edition one.
+        server.Port_set = false
+      }
+      err := server.QueryContext(ctx)
+      results <- result{server, err}
+    }(request_type, results)
+  }
+
+  var java result
+  for pending := 2; pending > 0; pending-- {
+    select {
+    case java = <-java_result:
+      if java.server.Online {
+        return java.server, java.err
+      }
+      java_result = nil
+    case bedrock := <-bedrock_result:
+      if bedrock.server.Online {
+        return bedrock.server, bedrock.err
+      }
+      bedrock_result = nil
+    }
+  }
+  return java.server, java.err
+}
+
+/* Rejects options that cannot apply to the transport of the requested protocol, rather than
+   silently ignoring them. Auto-detection uses each option with the protocols it applies to. */
+func (server *ServerStatus) check_transport() error {
+  if server.any_transport {
+    return nil
+  }
+  // Slices rather than maps, so that the first option set is always the one reported.
+  type transport_option struct {
+    name string
+    set bool
+  }
+  switch server.Request_type {
+  case REQUEST_NONE, REQUEST_JAVA:
+    return nil
+  case REQUEST_BEDROCK, REQUEST_QUERY:
+    tcp_options := []transport_option{
+      {"WithTLS()", server.Tls_config != nil},
+      {"WithHandshakeHost()", server.Handshake_host != ""},
+      {"WithProtocolVersion()", server.Handshake_protocol != -1},
+      {"WithFMLMarker()", server.Fml_marker != 0},
+      {"WithForwarding()", server.Forwarding != FORWARDING_NONE},
+      {"WithPing()", server.Ping},
+    }
+    for _, option := range tcp_options {
+      if option.set {
+        return fmt.Errorf("%s only applies to TCP, but %s uses UDP", option.name, request_names[server.Request_type])
+      }
+    }
+  default:
+    udp_options := []transport_option{
+      {"WithClientGUID()", server.Client_guid != 0},
+      {"WithRaknetMagic()", server.Raknet_magic != nil},
+    }
+    for _, option := range udp_options {
+      if option.set {
+        return fmt.Errorf("%s only applies to Bedrock over UDP, but %s uses TCP", option.name, request_names[server.Request_type])
+      }
+    }
+  }
+  return nil
+}
+
+/* Queries the address as a Java Edition server and as a Bedrock Edition server at the same
+   time, for servers accepting both like Java servers running Geyser. When both answer,
+   the Java Edition result is returned with Is_hybrid set, Bedrock_players holding the
+   player counts of the Bedrock side and the Bedrock-only fields (Bedrock_edition,
+   Server_guid, Port_ipv4, Port_ipv6) filled in. When only one answers, its result is
+   returned as is. The Bedrock side is pinged on bedrock_port, or when 0 on the port of
+   the _minecraft._udp SRV record or DEFAULT_BEDROCK_PORT, even if WithPort() is given. */
+func QueryHybrid(ctx context.Context, address string, bedrock_port uint16, opts ...Option) (*ServerStatus, error) {
+  java := NewServer(address, opts...)
+  java.Request_type = REQUEST_JAVA
+  bedrock := NewServer(address, opts...)
+  bedrock.Request_type = REQUEST_BEDROCK
+  bedrock.any_transport = true
+  bedrock.Port_set = false
+  if bedrock_port != 0 {
+    WithPort(bedrock_port)(bedrock)
+  }
+
+  var bedrock_err error
+  done := make(chan struct{})
+  go func() {
+    bedrock_err = bedrock.QueryContext(ctx)
+    close(done)
+  }()
+  java_err := java.QueryContext(ctx)
+  <-done
+
+  if !java.Online && bedrock.Online {
+    return bedrock, bedrock_err
+  }
+  if java.Online && bedrock.Online {
+    java.Is_hybrid = true
+    java.Bedrock_players = bedrock.Players
+    java.Bedrock_edition = bedrock.Bedrock_edition
+    java.Server_guid = bedrock.Server_guid
+    java.Port_ipv4 = bedrock.Port_ipv4
+    java.Port_ipv6 = bedrock.Port_ipv6
+  }
+  return java, java_err
+}
+
+/* Queries the address with every protocol, each over its own connection, and returns
+   the most complete result. Unlike Query(), which stops at the first protocol that
+   answers, this also picks up e.g. the full player list of the Query protocol.
+   When none of them succeed, the result and error of the JSON request are returned.
+   As with QueryHybrid(), a port given with WithPort() does not apply to Bedrock Edition. */
+func QueryAll(ctx context.Context, address string, opts ...Option) (*ServerStatus, error) {
+  var best *ServerStatus
+  var best_err error
+  tcp_refused := false
+  for _, request_type := range []uint16{REQUEST_JSON, REQUEST_EXTENDED, REQUEST_LEGACY, REQUEST_BETA, REQUEST_BEDROCK, REQUEST_QUERY} {
+    if ctx.Err() != nil {
+      break
+    }
+    // A refused connection will not succeed with a different TCP protocol either.
+    if tcp_refused && (request_type == REQUEST_EXTENDED || request_type == REQUEST_LEGACY || request_type == REQUEST_BETA) {
+      continue
+    }
+    server := NewServer(address, append(opts[:len(opts):len(opts)], WithProtocol(request_type))...)
+    server.any_transport = true
+    if request_type == REQUEST_BEDROCK {
+      // Bedrock Edition listens on its own port rather than the Java Edition one.
+      server.Port_set = false
+    }
+    err := server.QueryContext(ctx)
+    if request_type == REQUEST_JSON && server.Connection_status == RETURN_CONNFAIL {
+      tcp_refused = true
+    }
+    if best == nil || (server.Online && (!best.Online || server.richness() > best.richness())) {
+      best, best_err = server, err
+    }
+  }
+  if best == nil {
+    return NewServer(address, opts...), ctx.Err()
+  }
+  return best, best_err
+}
+
+/* Queries each address with at most concurrency queries running at a time and returns
+   the results in the same order as the addresses. The error of each query is left
+   in the Connection_status of its result. */
+func QueryMany(addresses []string, concurrency int, opts ...Option) []*ServerStatus {
+  results := make([]*ServerStatus, len(addresses))
+  if concurrency < 1 {
+    concurrency = 1
+  }
+  indexes := make(chan int)
+  var workers sync.WaitGroup
+  for worker := 0; worker < concurrency && worker < len(addresses); worker++ {
+    workers.Add(1)
+    go func() {
+      defer workers.Done()
+      for i := range indexes {
+        server := NewServer(addresses[i], opts...)
+        server.Query()
+        results[i] = server
+      }
+    }()
+  }
+  for i := range addresses {
+    indexes <- i
+  }
+  close(indexes)
+  workers.Wait()
+  return results
+}
+
+// Counts the fields a query filled in, to compare the results of different protocols.
+func (server *ServerStatus) richness() int {
+  count := 0
+  for _, filled := range []bool{
+    server.Version != "",
+    server.Protocol_version >= 0,
+    server.Motd != "",
+    server.Players.Max > 0,
+    len(server.Players.Sample) > 0,
+    len(server.Player_list) > 0,
+    len(server.Favicon) > 0,
+    server.Game_mode != "",
+    server.Server_id != "",
+    server.Map != "",
+    len(server.Plugins) > 0,
+  } {
+    if filled {
+      count++
+    }
+  }
+  return count
+}
+
+// Sends the request of a single protocol.
+func (server *ServerStatus) request(ctx context.Context, request_type uint16) (retval Status_code, err error) {
+  if server.Logger != nil {
+    server.debugf("%s:%d: trying the %s protocol", server.Address, server.Port, request_names[request_type])
+  }
+  switch request_type {
+  case REQUEST_LEGACY:
+    retval, err = server.legacy_request(ctx)
+  case REQUEST_EXTENDED:
+    retval, err = server.extended_request(ctx)
+  case REQUEST_JSON:
+    retval, err = server.json_request(ctx)
+  case REQUEST_BEDROCK:
+    retval, err = server.bedrock_request(ctx)
+  case REQUEST_QUERY:
+    retval, err = server.query_request(ctx)
+  case REQUEST_BETA:
+    retval, err = server.beta_request(ctx)
+  default:
+    return RETURN_UNKNOWN, fmt.Errorf("unknown request type %d", request_type)
+  }
+  if retval == RETURN_SUCCESS {
+    server.answered_request = request_type
+  }
+  if server.Logger != nil {
+    if retval == RETURN_SUCCESS {
+      server.debugf("%s:%d: %s protocol succeeded", server.Address, server.Port, request_names[request_type])
+    } else {
+      server.debugf("%s:%d: %s protocol failed with %s: %v", server.Address, server.Port, request_names[request_type], retval, err)
+    }
+  }
+  return retval, err
+}
+
+/* Queries the server and stores the results in the package globals.
+   The returned error wraps the underlying network error, if any.
+   An empty port looks up the _minecraft._tcp SRV record of the address instead. */
+func Init(given_address string, given_port string, optional_timeout ...int) error {
+  return InitContext(context.Background(), given_address, given_port, optional_timeout...)
+}
+
+// Same as Init(), but gives up as soon as ctx is canceled.
+func InitContext(ctx context.Context, given_address string, given_port string, optional_timeout ...int) error {
   timeout := DEFAULT_TIMEOUT
   if len(optional_timeout) > 0 {
     timeout = optional_timeout[0]
   }
-  Address = given_address
-  Port = given_port
-  /* Latency may report a misleading value of >1s due to name resolution delay when using net.Dial().
-     A workaround for this issue is to use an IP address instead of a hostname or FQDN. */
+  // Accept a combined "host:port" address as found in server lists.
+  if given_port == "" {
+    host, port, port_given, err := split_address(given_address)
+    if err == nil && port_given {
+      given_address, given_port = host, strconv.Itoa(int(port))
+    }
+  }
+  server := NewServer(given_address, WithTimeout(time.Duration(timeout) * time.Second), WithCaptureFields(Capture_fields), WithProtocol(Request_type))
+  var err error
+  if given_port != "" {
+    var port uint64
+    port, err = strconv.ParseUint(given_port, 10, 16)
+    WithPort(uint16(port))(server)
+  }
+  if err != nil {
+    err = fmt.Errorf("invalid port %q: %w", given_port, err)
+  } else {
+    err = server.QueryContext(ctx)
+  }
+  set_globals(server)
+  if given_port != "" {
+    Port = given_port
+  }
+  return err
+}
+
+/* Clears the results of the last Init() from the package globals. Init() overwrites
+   every one of them itself, so this is only needed to forget a previous result. */
+func Reset() {
+  set_globals(NewServer(""))
+  Port = ""
+}
+
+/* Copies the results of a query into the package globals. Every result global
+   must be set here, so that nothing is left over from a previous query. */
+func set_globals(server *ServerStatus) {
+  Address = server.Address
+  Port = strconv.Itoa(int(server.Port))
+  Online = server.Online
+  Edition = server.Edition
+  Bedrock_edition = server.Bedrock_edition
+  Version = server.Version
+  Version_name = server.Version_name
+  Protocol_version = server.Protocol_version
+  Motd = server.Motd
+  Motd_clean = server.Motd_clean
+  Motd_safe = server.Motd_safe
+  Motd_length = server.Motd_length
+  Motd_truncated = server.Motd_truncated
+  Motd_components = server.Motd_components
+  Current_players = ""
+  Max_players = ""
+  if server.Online {
+    Current_players = strconv.Itoa(int(server.Players.Online))
+    Max_players = strconv.Itoa(int(server.Players.Max))
+  }
+  Players = server.Players.Sample
+  // The global has always been rounded to whole milliseconds.
+  Latency = server.Latency.Round(time.Millisecond)
+  Protocol = server.Protocol
+  Connection_status = server.Connection_status
+  Favicon = server.Favicon
+  Favicon_base64 = server.Favicon_base64
+  Game_mode = server.Game_mode
+  Game_mode_id = server.Game_mode_id
+  Server_id = server.Server_id
+  Server_guid = server.Server_guid
+  Timestamp_mismatch = server.Timestamp_mismatch
+  Port_ipv4 = server.Port_ipv4
+  Port_ipv6 = server.Port_ipv6
+  Map = server.Map
+  Plugins = server.Plugins
+  Player_list = server.Player_list
+  Mods = server.Mods
+  Is_modded = server.Is_modded
+  Enforces_secure_chat = server.Enforces_secure_chat
+  Previews_chat = server.Previews_chat
+  Prevents_chat_reports = server.Prevents_chat_reports
+  Version_mismatch = server.Version_mismatch
+  Maintenance = server.Maintenance
+  Is_proxy_fallback = server.Is_proxy_fallback
+  Raw_json = server.Raw_json
+  Fields = server.Fields
+}
+
+/* Looks up the _minecraft._tcp SRV record of the address unless a port was given
+   explicitly. Without a record, the address and port are used as they are.
+   Without a TCP record, the _minecraft._udp record of a Bedrock server is looked up
+   for the Bedrock ping, which otherwise uses the address with port 19132. */
+func (server *ServerStatus) resolve_srv(ctx context.Context) {
   start_time := time.Now()
-  conn, err := net.DialTimeout("tcp", Address + ":" + Port, time.Duration(timeout) * time.Second)
-  Latency = time.Since(start_time)
-  Latency = Latency.Round(time.Millisecond)
+  defer func() {
+    server.srv_lookup = time.Since(start_time)
+  }()
+  server.dial_address = server.Address
+  server.dial_port = server.Port
+  server.bedrock_address = server.Address
+  server.bedrock_port = server.Port
+  if !server.Port_set {
+    server.bedrock_port = DEFAULT_BEDROCK_PORT
+  }
+  if server.Port_set || net.ParseIP(server.Address) != nil {
+    return
+  }
+  if server.Request_type != REQUEST_BEDROCK {
+    _, records, err := server.resolver().LookupSRV(ctx, "minecraft", "tcp", server.Address)
+    if err == nil && len(records) > 0 {
+      server.dial_address = strings.TrimSuffix(records[0].Target, ".")
+      server.dial_port = records[0].Port
+      return
+    }
+  }
+  if server.Request_type == REQUEST_NONE || server.Request_type == REQUEST_BEDROCK {
+    _, records, err := server.resolver().LookupSRV(ctx, "minecraft", "udp", server.Address)
+    if err == nil && len(records) > 0 {
+      server.bedrock_address = strings.TrimSuffix(records[0].Target, ".")
+      server.bedrock_port = records[0].Port
+    }
+  }
+}
+
+func (server *ServerStatus) connect(ctx context.Context) (net.Conn, Status_code, error) {
+  return server.dial(ctx, "tcp", server.dial_address, server.dial_port)
+}
+
+func (server *ServerStatus) dial(ctx context.Context, network string, address string, port uint16) (net.Conn, Status_code, error) {
+  server.Timings = Timings{DNS: server.srv_lookup}
+  if server.conn != nil {
+    server.Resolved_address, server.Resolved_port = address, port
+    server.set_deadline(ctx, server.conn)
+    return &timing_conn{Conn: borrowed_conn{server.conn}, timings: &server.Timings}, RETURN_SUCCESS, nil
+  }
+  dialer := net.Dialer{Timeout: server.Timeout}
+  if server.Dialer != nil {
+    dialer = *server.Dialer
+    if dialer.Timeout == 0 {
+      dialer.Timeout = server.Timeout
+    }
+  }
+  if server.Local_addr != nil {
+    dialer.LocalAddr = local_addr(network, server.Local_addr)
+  }
+  dial_func := dialer.DialContext
+  if server.Dial_func != nil {
+    // Custom dial functions get no dialer, so bound the connect with the context instead.
+    dial_func = func(ctx context.Context, network string, address string) (net.Conn, error) {
+      dial_ctx, cancel := context.WithTimeout(ctx, server.Timeout)
+      defer cancel()
+      return server.Dial_func(dial_ctx, network, address)
+    }
+  }
+  /* Resolve the name first so that Latency only covers the connect itself and not
+     the DNS lookup, which could otherwise add a misleading second or more.
+     Custom dial functions get the name as it is, since a proxy may resolve it on its end. */
+  ips := []string{address}
+  if net.ParseIP(address) == nil && server.Dial_func == nil {
+    var err error
+    start_time := time.Now()
+    ips, err = server.resolver().LookupHost(ctx, address)
+    server.Timings.DNS += time.Since(start_time)
+    if err != nil {
+      if server.Logger != nil {
+        server.debugf("%s: lookup failed: %v", address, err)
+      }
+      retval, err := connect_error(err)
+      return nil, retval, err
+    }
+    if server.Logger != nil {
+      server.debugf("%s: resolved to %v", address, ips)
+    }
+  }
+  var conn net.Conn
+  var err error
+  // Try each resolved address in turn like net.Dial() would.
+  for _, ip := range ips {
+    start_time := time.Now()
+    conn, err = dial_func(ctx, network, net.JoinHostPort(ip, strconv.Itoa(int(port))))
+    server.Latency = time.Since(start_time)
+    if err == nil || ctx.Err() != nil {
+      break
+    }
+    if server.Logger != nil {
+      server.debugf("%s: %s dial failed: %v", net.JoinHostPort(ip, strconv.Itoa(int(port))), network, err)
+    }
+  }
   if err != nil {
-    Online = false
+    retval, err := connect_error(err)
+    return nil, retval, err
+  }
+  if server.Logger != nil {
+    server.debugf("%s: %s connected in %s", conn.RemoteAddr(), network, server.Latency)
+  }
+  if network == "tcp" {
+    server.Timings.Connect = server.Latency
+  }
+  server.Resolved_address = address
+  server.Resolved_port = port
+  server.set_deadline(ctx, conn)
+  if server.Tls_config != nil && network == "tcp" {
+    var retval Status_code
+    conn, retval, err = server.wrap_tls(ctx, conn)
+    if retval != RETURN_SUCCESS {
+      return nil, retval, err
+    }
+  }
+  return &timing_conn{Conn: conn, timings: &server.Timings}, RETURN_SUCCESS, nil
+}
+
+// Performs the TLS handshake on a new connection, which the deadline of conn already bounds.
+func (server *ServerStatus) wrap_tls(ctx context.Context, conn net.Conn) (net.Conn, Status_code, error) {
+  config := server.Tls_config
+  if config.ServerName == "" {
+    config = config.Clone()
+    config.ServerName = server.Address
+  }
+  tls_conn := tls.Client(conn, config)
+  err := tls_conn.HandshakeContext(ctx)
+  if err != nil {
+    conn.Close()
+    if server.Logger != nil {
+      server.debugf("%s: TLS handshake failed: %v", conn.RemoteAddr(), err)
+    }
+    retval, err := connect_error(err)
+    return nil, retval, err
+  }
+  return tls_conn, RETURN_SUCCESS, nil
+}
+
+// Resolver for SRV records and addresses (net.DefaultResolver unless set with WithResolver()).
+func (server *ServerStatus) resolver() *net.Resolver {
+  if server.Resolver != nil {
+    return server.Resolver
+  }
+  return net.DefaultResolver
+}
+
+// Converts a local address between TCP and UDP, since dialers reject the other kind.
+func local_addr(network string, addr net.Addr) net.Addr {
+  switch addr := addr.(type) {
+  case *net.TCPAddr:
+    if network == "udp" {
+      return &net.UDPAddr{IP: addr.IP, Port: addr.Port, Zone: addr.Zone}
+    }
+  case *net.UDPAddr:
+    if network == "tcp" {
+      return &net.TCPAddr{IP: addr.IP, Port: addr.Port, Zone: addr.Zone}
+    }
+  }
+  return addr
+}
+
+// Host name written into the handshake (Address unless set with WithHandshakeHost()).
+func (server *ServerStatus) handshake_host() string {
+  if server.Handshake_host != "" {
+    return server.Handshake_host
+  }
+  return server.Address
+}
+
+/* Bounds the reads and writes on a connection by the read timeout and the context deadline,
+   so a server that accepts the connection but never replies cannot hang the query. */
+func (server *ServerStatus) set_deadline(ctx context.Context, conn net.Conn) {
+  read_timeout := server.Read_timeout
+  if read_timeout == 0 {
+    read_timeout = server.Timeout
+  }
+  deadline := time.Now().Add(read_timeout)
+  if ctx_deadline, ok := ctx.Deadline(); ok && ctx_deadline.Before(deadline) {
+    deadline = ctx_deadline
+  }
+  conn.SetDeadline(deadline)
+}
+
+// Maps an error raised while connecting to a status code and a *ConnectError telling why.
+func connect_error(err error) (Status_code, error) {
+  failure := FAILURE_OTHER
+  var dns_error *net.DNSError
+  switch {
+  case is_timeout(err):
+    failure = FAILURE_TIMEOUT
+  case errors.As(err, &dns_error):
+    failure = FAILURE_DNS
+  case errors.Is(err, syscall.ECONNREFUSED):
+    failure = FAILURE_REFUSED
+  case errors.Is(err, syscall.EHOSTUNREACH) || errors.Is(err, syscall.ENETUNREACH):
+    failure = FAILURE_UNREACHABLE
+  }
+  if failure == FAILURE_TIMEOUT {
+    return RETURN_TIMEOUT, fmt.Errorf("connection timed out: %w", &ConnectError{failure, err})
+  }
+  return RETURN_CONNFAIL, fmt.Errorf("connection failed: %w", &ConnectError{failure, err})
+}
+
+// Maps an error raised while talking to a connected server to a status code.
+func io_error(err error) (Status_code, error) {
+  if is_timeout(err) {
+    return RETURN_TIMEOUT, fmt.Errorf("connection timed out: %w", err)
+  }
+  return RETURN_UNKNOWN, fmt.Errorf("unexpected response: %w", err)
+}
+
+func is_timeout(err error) bool {
+  var net_error net.Error
+  return errors.As(err, &net_error) && net_error.Timeout()
+}
+
+/*
+  1.7+ (JSON) Server List Ping
+  https://wiki.vg/Server_List_Ping#Current
+  1. Client sends a handshake packet:
+    a. 0x00 (packet ID)
+    b. protocol version as a VarInt (-1 by default, the standard value when pinging to determine the version)
+    c. server address as a VarInt-prefixed UTF-8 string (Forge clients append an FML marker,
+       BungeeCord appends the forwarded client IP and UUID)
+    d. server port as an unsigned short
+    e. 0x01 (next state: status) as a VarInt
+  2. Client sends a status request packet (0x01 0x00: length 1, packet ID 0x00)
+  3. Server responds with a status response packet:
+    a. packet length as a VarInt
+    b. 0x00 (packet ID) as a VarInt
+    c. JSON status as a VarInt-prefixed UTF-8 string
+  4. Optionally (WithPing()), client sends a ping packet (see PingLatency())
+  5. Server responds with a pong packet and closes the connection
+  The order is strict: some servers and anti-bot plugins hang up on a ping sent before
+  the status response has been read, and many hang up right after the status response.
+*/
+func (server *ServerStatus) json_request(ctx context.Context) (Status_code, error) {
+  conn, retval, err := server.connect(ctx)
+  if retval != RETURN_SUCCESS {
+    return retval, err
+  }
+  defer conn.Close()
+
+  reader, raw_json, retval, err := server.read_status(conn)
+  if retval != RETURN_SUCCESS {
+    return retval, err
+  }
+  // Kept even if it fails to parse below, to help debugging.
+  server.Raw_json = raw_json
+  server.Ping_latency = 0
+  if server.Ping {
+    // The status is already in hand, so a server hanging up here only costs the ping.
+    server.Ping_latency, err = ping(conn, reader)
+    if err != nil && server.Logger != nil {
+      server.debugf("%s:%d: ping after the status response failed: %v", server.Address, server.Port, err)
+    }
+  }
+
+  var status struct {
+    Version struct {
+      Name string `json:"name"`
+      Protocol *int `json:"protocol"`
+    } `json:"version"`
+    Players struct {
+      Max int `json:"max"`
+      Online int `json:"online"`
+      Sample []PlayerSample `json:"sample"`
+    } `json:"players"`
+    Description chat_text `json:"description"`
+    Favicon string `json:"favicon"`
+    Enforces_secure_chat *bool `json:"enforcesSecureChat"`
+    Previews_chat *bool `json:"previewsChat"`
+    Prevents_chat_reports *bool `json:"preventsChatReports"`
+    forge_status
+  }
+  err = json.Unmarshal(raw_json, &status)
+  if err != nil {
+    return RETURN_UNKNOWN, server.field_error("status", err)
+  }
+
+  server.Protocol = "SLP 1.7 (JSON)"
+  server.Version = status.Version.Name
+  if status.Version.Protocol != nil {
+    server.Protocol_version = *status.Version.Protocol
+  }
+  server.Motd = status.Description.text
+  server.Motd_components = status.Description.components
+  server.Players.Online = player_count(status.Players.Online)
+  server.Players.Max = player_count(status.Players.Max)
+  // Many servers omit the sample or send null, which leaves Players empty.
+  server.Players.Sample = status.Players.Sample
+  server.parse_favicon(status.Favicon)
+  server.Enforces_secure_chat = status.Enforces_secure_chat
+  server.Previews_chat = status.Previews_chat
+  server.Prevents_chat_reports = status.Prevents_chat_reports
+  server.parse_forge(status.forge_status)
+  server.detect_maintenance()
+  server.detect_proxy_fallback()
+  return RETURN_SUCCESS, nil
+}
+
+// Sends the handshake and status request, then reads the JSON status response.
+func (server *ServerStatus) read_status(conn net.Conn) (*bufio.Reader, []byte, Status_code, error) {
+  io_failure := func(err error) (*bufio.Reader, []byte, Status_code, error) {
+    retval, err := io_error(err)
+    return nil, nil, retval, err
+  }
+  var handshake bytes.Buffer
+  WriteVarInt(&handshake, server.Handshake_protocol)
+  // Always the address as given rather than an IP or SRV target, since proxies route on it.
+  handshake_address := server.handshake_host()
+  if server.Forwarding == FORWARDING_BUNGEECORD {
+    // What BungeeCord sends to its backends: the client IP and UUID (without dashes).
+    handshake_address += "\x00" + server.Forwarding_ip + "\x00" + strings.Replace(server.Forwarding_uuid, "-", "", -1)
+  }
+  switch server.Fml_marker {
+  case 1:
+    handshake_address += "\x00FML\x00"
+  case 2, 3:
+    handshake_address += fmt.Sprintf("\x00FML%d\x00", server.Fml_marker)
+  }
+  WriteVarInt(&handshake, len(handshake_address))
+  handshake.WriteString(handshake_address)
+  binary.Write(&handshake, binary.BigEndian, server.dial_port)
+  WriteVarInt(&handshake, 1)
+
+  // The handshake and the status request go out together.
+  var request bytes.Buffer
+  WritePacket(&request, 0x00, handshake.Bytes())
+  WritePacket(&request, 0x00, nil)
+  _, err := conn.Write(request.Bytes())
+  if err != nil {
+    return io_failure(err)
+  }
+
+  reader := bufio.NewReader(conn)
+  // Do not let a hostile server make us allocate whatever length it claims. The JSON
+  // may take up the whole limit, so leave room for the packet ID and the JSON length.
+  packet_id, payload, err := ReadPacket(reader, server.Max_response_bytes + 6)
+  if err != nil {
+    return io_failure(err)
+  }
+  if packet_id != 0x00 {
+    return nil, nil, RETURN_UNKNOWN, fmt.Errorf("unexpected packet ID 0x%02X", packet_id)
+  }
+  payload_reader := bytes.NewReader(payload)
+  json_length, err := ReadVarInt(payload_reader)
+  if err != nil {
+    return io_failure(err)
+  }
+  if json_length <= 0 {
+    return nil, nil, RETURN_UNKNOWN, errors.New("empty status response")
+  }
+  if json_length > payload_reader.Len() {
+    return nil, nil, RETURN_UNKNOWN, fmt.Errorf("status response of %d bytes exceeds its packet of %d bytes", json_length, payload_reader.Len())
+  }
+  json_start := len(payload) - payload_reader.Len()
+  raw_json := payload[json_start:json_start + json_length]
+  if server.Logger != nil {
+    server.debugf("%s:%d: status response: %s", server.Address, server.Port, raw_json)
+  }
+  return reader, raw_json, RETURN_SUCCESS, nil
+}
+
+/*
+  Measures the round trip of the 1.7+ ping packet, which unlike Latency leaves out
+  the DNS lookup and the TCP handshake. The status response is read first, since
+  some servers and proxies only answer the ping after it.
+  1. Client sends a ping packet:
+    a. packet length as a VarInt (9)
+    b. 0x01 (packet ID)
+    c. arbitrary payload as a long
+  2. Server responds with a pong packet carrying the same payload
+*/
+func PingLatency(address string, opts ...Option) (time.Duration, error) {
+  samples, err := PingSamples(address, 1, opts...)
+  if err != nil {
+    return 0, err
+  }
+  return samples[0], nil
+}
+
+/* Measures the round trip of count ping packets, e.g. to compute the jitter. The pings
+   share one connection where the server allows it. Vanilla servers hang up after the
+   first pong, so a new connection is opened for each of them there. The samples taken
+   before an error are returned along with it. */
+func PingSamples(address string, count int, opts ...Option) ([]time.Duration, error) {
+  if count < 1 {
+    return nil, fmt.Errorf("invalid ping count %d", count)
+  }
+  server := NewServer(address, opts...)
+  ctx := context.Background()
+  server.resolve_srv(ctx)
+  samples := make([]time.Duration, 0, count)
+  var conn net.Conn
+  var reader *bufio.Reader
+  pongs := 0 // pongs received on the current connection
+  for len(samples) < count {
+    if conn == nil {
+      var retval Status_code
+      var err error
+      conn, retval, err = server.connect(ctx)
+      if retval != RETURN_SUCCESS {
+        return samples, err
+      }
+      reader, _, retval, err = server.read_status(conn)
+      if retval != RETURN_SUCCESS {
+        conn.Close()
+        return samples, err
+      }
+      pongs = 0
+    }
+    server.set_deadline(ctx, conn)
+    latency, err := ping(conn, reader)
+    if err != nil {
+      conn.Close()
+      conn = nil
+      // The server hung up after an earlier pong, so try again on a new connection.
+      if pongs > 0 {
+        continue
+      }
+      return samples, err
+    }
+    samples = append(samples, latency)
+    pongs++
+  }
+  if conn != nil {
+    conn.Close()
+  }
+  return samples, nil
+}
+
+// Sends a ping packet and times the pong.
+func ping(conn net.Conn, reader *bufio.Reader) (time.Duration, error) {
+  payload := make([]byte, 8)
+  binary.BigEndian.PutUint64(payload, uint64(time.Now().UnixNano()))
+  start_time := time.Now()
+  err := WritePacket(conn, 0x01, payload)
+  if err != nil {
+    _, err = io_error(err)
+    return 0, err
+  }
+  packet_id, pong, err := ReadPacket(reader, 16)
+  latency := time.Since(start_time)
+  if err != nil {
+    _, err = io_error(err)
+    return 0, err
+  }
+  if packet_id != 0x01 || !bytes.Equal(pong, payload) {
+    return 0, errors.New("invalid pong")
+  }
+  return latency, nil
+}
+
+/* Guesses whether the server deliberately locks players out. Servers in maintenance usually
+   advertise a fake version such as "Maintenance" with a bogus protocol number, which makes
+   the client show them as incompatible, and often have no slots or say so in the MOTD. */
+func (server *ServerStatus) detect_maintenance() {
+  // 4 is the protocol of 1.7.2, the first release with the JSON status response.
+  server.Version_mismatch = server.Protocol_version >= 0 && server.Protocol_version < 4 ||
+    !strings.ContainsAny(server.Version, "0123456789")
+  motd := strings.ToLower(StripFormatting(server.Motd))
+  server.Maintenance = server.Version_mismatch || server.Players.Max == 0 ||
+    strings.Contains(motd, "maintenance") || strings.Contains(motd, "whitelist")
+}
+
+// Checks the MOTD against Proxy_fallback_motds.
+func (server *ServerStatus) detect_proxy_fallback() {
+  motd := strings.ToLower(StripFormatting(server.Motd))
+  for _, fallback_motd := range Proxy_fallback_motds {
+    if strings.Contains(motd, strings.ToLower(fallback_motd)) {
+      server.Is_proxy_fallback = true
+      return
+    }
+  }
+}
+
+/* Notes a field that could not be parsed in Parse_errors, whether or not it fails the query,
+   and returns the error to fail the query with. */
+func (server *ServerStatus) field_error(field string, err error) error {
+  server.Parse_errors = append(server.Parse_errors, field + ": " + err.Error())
+  if server.Logger != nil {
+    server.warnf("%s:%d: invalid %s: %v", server.Address, server.Port, field, err)
+  }
+  return fmt.Errorf("invalid %s: %w", field, err)
+}
+
+/* Decodes the "data:image/png;base64,..." favicon data URI. A broken favicon
+   is ignored rather than failing the whole query. */
+func (server *ServerStatus) parse_favicon(favicon string) {
+  comma := strings.Index(favicon, ",")
+  if !strings.HasPrefix(favicon, "data:") || comma < 0 {
+    return
+  }
+  // Some older servers wrap the base64 data across several lines.
+  favicon_base64 := strings.Replace(favicon[comma + 1:], "\n", "", -1)
+  image, err := base64.StdEncoding.DecodeString(favicon_base64)
+  if err != nil {
+    server.field_error("favicon", err)
     return
   }
+  server.Favicon = image
+  server.Favicon_base64 = favicon_base64
+}
+
+/*
+  1.6 (extended legacy) Server List Ping
+  https://wiki.vg/Server_List_Ping#1.6
+  1. Client sends:
+    a. 0xFE 0x01 (server list ping)
+    b. 0xFA (plugin message)
+    c. 0x00 0x0B (length of the following string: 11) and "MC|PingHost" as UTF-16BE
+    d. length of the rest of the data (7 + length of hostname in bytes) as a short
+    e. protocol version as a byte (74 for 1.6.2)
+    f. length of hostname as a short and the hostname as UTF-16BE
+    g. port as an int
+  2. Server responds with a kick packet in the same format as the legacy ping
+*/
+func (server *ServerStatus) extended_request(ctx context.Context) (Status_code, error) {
+  conn, retval, err := server.connect(ctx)
+  if retval != RETURN_SUCCESS {
+    return retval, err
+  }
+  defer conn.Close()
+
+  hostname := utf16.Encode([]rune(server.handshake_host()))
+  var request bytes.Buffer
+  request.Write([]byte("\xFE\x01\xFA"))
+  binary.Write(&request, binary.BigEndian, uint16(11))
+  binary.Write(&request, binary.BigEndian, utf16.Encode([]rune("MC|PingHost")))
+  binary.Write(&request, binary.BigEndian, uint16(7 + len(hostname) * 2))
+  request.WriteByte(74)
+  binary.Write(&request, binary.BigEndian, uint16(len(hostname)))
+  binary.Write(&request, binary.BigEndian, hostname)
+  binary.Write(&request, binary.BigEndian, uint32(server.dial_port))
+  _, err = conn.Write(request.Bytes())
+  if err != nil {
+    return io_error(err)
+  }
+
+  return server.parse_data(conn, "SLP 1.6 (extended)")
+}
+
+/*
+  1.4/1.5 (legacy) Server List Ping
+  https://wiki.vg/Server_List_Ping#1.4_to_1.5
+*/
+func (server *ServerStatus) legacy_request(ctx context.Context) (Status_code, error) {
+  conn, retval, err := server.connect(ctx)
+  if retval != RETURN_SUCCESS {
+    return retval, err
+  }
+  defer conn.Close()
 
   _, err = conn.Write([]byte("\xFE\x01"))
   if err != nil {
-    Online = false
-    return
+    return io_error(err)
+  }
+
+  return server.parse_data(conn, "SLP 1.4/1.5 (legacy)")
+}
+
+/*
+  Parses the kick packet sent in response to the legacy and extended pings.
+  Its payload holds six NUL-delimited fields: "§1", protocol version,
+  server version, MOTD, current players and max players.
+*/
+func (server *ServerStatus) parse_data(conn io.Reader, protocol string) (Status_code, error) {
+  payload, retval, err := server.read_kick(conn)
+  if retval != RETURN_SUCCESS {
+    return retval, err
   }
 
-  raw_data := make([]byte, 512)
-  _, err = conn.Read(raw_data)
+  data := strings.Split(payload, "\x00")
+  if server.Capture_fields {
+    server.Fields = data
+  }
+  if len(data) < NUM_FIELDS {
+    return RETURN_UNKNOWN, fmt.Errorf("expected %d fields, got %d", NUM_FIELDS, len(data))
+  }
+  // Real responses start with the "§1" marker, other services may coincidentally send nulls.
+  if data[0] != "§1" {
+    return RETURN_UNKNOWN, fmt.Errorf("response does not start with the §1 marker: %q", data[0])
+  }
+  /* Take the player counts and the MOTD from the end, since some modified servers put
+     null bytes in the version, which shift every later field by one or more. */
+  last := len(data) - 1
+  current_players, err := parse_player_count(data[last - 1])
   if err != nil {
-    Online = false
-    return
+    return RETURN_UNKNOWN, server.field_error("current_players", err)
+  }
+  max_players, err := parse_player_count(data[last])
+  if err != nil {
+    return RETURN_UNKNOWN, server.field_error("max_players", err)
   }
-  conn.Close()
 
-  if raw_data == nil || len(raw_data) == 0 {
-    Online = false
-    return
+  server.Protocol = protocol
+  // A malformed protocol number should not fail the whole query.
+  protocol_version, err := strconv.Atoi(data[1])
+  if err == nil {
+    server.Protocol_version = protocol_version
+  } else {
+    server.field_error("protocol_version", err)
+  }
+  server.Version = strings.Join(data[2:last - 2], "\x00")
+  server.Motd = data[last - 2]
+  server.Players.Online = current_players
+  server.Players.Max = max_players
+  return RETURN_SUCCESS, nil
+}
+
+// Reads the payload of the kick packet sent in response to the beta, legacy and extended pings.
+func (server *ServerStatus) read_kick(conn io.Reader) (string, Status_code, error) {
+  // 0xFF (kick packet) followed by the payload length in characters as a big-endian short
+  header := make([]byte, 3)
+  _, err := io.ReadFull(conn, header)
+  if err != nil {
+    retval, err := io_error(err)
+    return "", retval, err
+  }
+  if header[0] != 0xFF {
+    return "", RETURN_UNKNOWN, fmt.Errorf("unexpected packet ID 0x%02X", header[0])
+  }
+
+  // The payload is UTF-16BE (two bytes per character) and may arrive over several reads.
+  payload_length := int(binary.BigEndian.Uint16(header[1:])) * 2
+  if payload_length > server.Max_response_bytes {
+    return "", RETURN_UNKNOWN, fmt.Errorf("response of %d bytes exceeds the limit of %d bytes", payload_length, server.Max_response_bytes)
+  }
+  raw_data := make([]byte, payload_length)
+  length, err := io.ReadFull(conn, raw_data)
+  // Servers sending a single-byte encoding send one byte per character before hanging up,
+  // whereas a UTF-16BE payload cut short by the connection dropping is an error.
+  if err == io.ErrUnexpectedEOF && length == payload_length / 2 && !is_utf16be(raw_data[:length - length % 2]) {
+    raw_data = raw_data[:length]
+  } else if err != nil {
+    retval, err := io_error(err)
+    return "", retval, err
+  }
+  payload := decode_payload(raw_data)
+  if server.Logger != nil {
+    server.debugf("%s:%d: kick payload: %q", server.Address, server.Port, payload)
+  }
+  return payload, RETURN_SUCCESS, nil
+}
+
+/* Whether the payload is UTF-16BE: it starts with the "§1" marker of a 1.4+ response in
+   UTF-16BE, or it does not start with that marker in UTF-8 or Latin-1 and decodes as
+   UTF-16BE without unpaired surrogates. This holds for MOTDs in any script. */
+func is_utf16be(raw_data []byte) bool {
+  if bytes.HasPrefix(raw_data, []byte("\x00\xA7\x001")) {
+    return true
+  }
+  if bytes.HasPrefix(raw_data, []byte("\xC2\xA71")) || bytes.HasPrefix(raw_data, []byte("\xA71")) || len(raw_data) % 2 != 0 {
+    return false
+  }
+  for i := 0; i < len(raw_data); i += 2 {
+    character := rune(binary.BigEndian.Uint16(raw_data[i:]))
+    if !utf16.IsSurrogate(character) {
+      continue
+    }
+    // A high surrogate (0xD800-0xDBFF) followed by a low one (0xDC00-0xDFFF)
+    if character >= 0xDC00 || i + 3 >= len(raw_data) || raw_data[i + 2] & 0xFC != 0xDC {
+      return false
+    }
+    i += 2
+  }
+  return true
+}
+
+/* Decodes a kick packet payload. It should be UTF-16BE, but some modified servers send
+   UTF-8 or Latin-1 instead, which is recognized by is_utf16be(). */
+func decode_payload(raw_data []byte) string {
+  if is_utf16be(raw_data) {
+    return decode_utf16be(raw_data)
+  }
+  if utf8.Valid(raw_data) {
+    return string(raw_data)
+  }
+  // Latin-1 maps each byte to the code point of the same value.
+  characters := make([]rune, len(raw_data))
+  for i, value := range raw_data {
+    characters[i] = rune(value)
+  }
+  return string(characters)
+}
+
+/*
+  Beta 1.8 to 1.3 Server List Ping
+  https://wiki.vg/Server_List_Ping#Beta_1.8_to_1.3
+  1. Client sends 0xFE (server list ping)
+  2. Server responds with a kick packet whose payload holds
+     the MOTD, current players and max players delimited by "§"
+*/
+func (server *ServerStatus) beta_request(ctx context.Context) (Status_code, error) {
+  conn, retval, err := server.connect(ctx)
+  if retval != RETURN_SUCCESS {
+    return retval, err
+  }
+  defer conn.Close()
+
+  _, err = conn.Write([]byte("\xFE"))
+  if err != nil {
+    return io_error(err)
+  }
+
+  return server.parse_beta(conn)
+}
+
+func (server *ServerStatus) parse_beta(conn io.Reader) (Status_code, error) {
+  payload, retval, err := server.read_kick(conn)
+  if retval != RETURN_SUCCESS {
+    return retval, err
+  }
+
+  data := strings.Split(payload, "§")
+  if server.Capture_fields {
+    server.Fields = data
+  }
+  if len(data) < NUM_FIELDS_BETA {
+    return RETURN_UNKNOWN, fmt.Errorf("expected %d fields, got %d", NUM_FIELDS_BETA, len(data))
+  }
+  // The player counts are always the last two fields, as the MOTD may contain "§" color codes itself.
+  current_players, err := parse_player_count(data[len(data) - 2])
+  if err != nil {
+    return RETURN_UNKNOWN, server.field_error("current_players", err)
+  }
+  max_players, err := parse_player_count(data[len(data) - 1])
+  if err != nil {
+    return RETURN_UNKNOWN, server.field_error("max_players", err)
+  }
+
+  server.Protocol = "SLP 1.8b/1.3 (beta)"
+  // The response does not tell the version.
+  server.Version = ">=1.8b/1.3"
+  server.Motd = strings.Join(data[:len(data) - 2], "§")
+  server.Players.Online = current_players
+  server.Players.Max = max_players
+  return RETURN_SUCCESS, nil
+}
+
+/*
+  Bedrock/Pocket Edition unconnected ping (RakNet)
+  https://wiki.vg/Raknet_Protocol#Unconnected_Ping
+  1. Client sends:
+    a. 0x01 (unconnected ping)
+    b. client time as a long
+    c. RakNet magic (00 FF FF 00 FE FE FE FE FD FD FD FD 12 34 56 78)
+    d. client GUID as a long
+  2. Server responds with:
+    a. 0x1C (unconnected pong)
+    b. echoed client time as a long
+    c. server GUID as a long
+    d. RakNet magic
+    e. length of the server ID string as a short
+    f. server ID string, semicolon-delimited:
+       edition;MOTD line 1;protocol;version;players;max players;server unique ID;
+       MOTD line 2;game mode;numeric game mode;IPv4 port;IPv6 port;
+*/
+func (server *ServerStatus) bedrock_request(ctx context.Context) (Status_code, error) {
+  magic := server.Raknet_magic
+  if magic == nil {
+    magic = DEFAULT_RAKNET_MAGIC
+  }
+  // The pong is parsed at fixed offsets, which only hold for a magic of the usual length.
+  if len(magic) != 16 {
+    return RETURN_UNKNOWN, fmt.Errorf("RakNet magic of %d bytes, expected 16", len(magic))
+  }
+  conn, retval, err := server.dial(ctx, "udp", server.bedrock_address, server.bedrock_port)
+  if retval != RETURN_SUCCESS {
+    return retval, err
+  }
+  defer conn.Close()
+
+  var request bytes.Buffer
+  request.WriteByte(0x01)
+  client_time := time.Now().UnixNano() / int64(time.Millisecond)
+  binary.Write(&request, binary.BigEndian, client_time)
+  request.Write(magic)
+  // A random client GUID by default, so that servers cannot block all pings sharing one.
+  // crypto/rand, since math/rand gives every process the same sequence before Go 1.20.
+  client_guid := server.Client_guid
+  if client_guid == 0 {
+    var random [8]byte
+    rand.Read(random[:])
+    client_guid = binary.BigEndian.Uint64(random[:])
+  }
+  binary.Write(&request, binary.BigEndian, client_guid)
+  // There is no connection to time over UDP, so the latency is the round trip of the ping.
+  start_time := time.Now()
+  _, err = conn.Write(request.Bytes())
+  if err != nil {
+    return io_error(err)
+  }
+
+  // Pongs with long MOTDs and many fields can exceed 1 KB.
+  pong, retval, err := server.read_datagram(ctx, conn, 4096)
+  if retval != RETURN_SUCCESS {
+    return retval, err
+  }
+  server.Latency = time.Since(start_time)
+
+  retval, err = server.parse_bedrock(pong)
+  // Only a broken server or something in between rewrites the echoed time.
+  if retval == RETURN_SUCCESS {
+    server.Timestamp_mismatch = int64(binary.BigEndian.Uint64(pong[1:9])) != client_time
+  }
+  return retval, err
+}
+
+// Reads a single UDP datagram of up to size bytes.
+func (server *ServerStatus) read_datagram(ctx context.Context, conn net.Conn, size int) ([]byte, Status_code, error) {
+  // UDP has no connection to time out, so bound the wait for the response.
+  server.set_deadline(ctx, conn)
+  // Longer datagrams are cut short and then fail to parse.
+  if size > server.Max_response_bytes {
+    size = server.Max_response_bytes
+  }
+  buffer := make([]byte, size)
+  length, err := conn.Read(buffer)
+  if err != nil {
+    // A refused connection is usually an ICMP port unreachable: nothing is listening.
+    retval, err := connect_error(err)
+    return nil, retval, err
+  }
+  if server.Logger != nil {
+    server.debugf("%s:%d: received %d bytes: %q", server.Address, server.Port, length, buffer[:length])
+  }
+  return buffer[:length], RETURN_SUCCESS, nil
+}
+
+// Parses an unconnected pong. Any field beyond the player counts is optional.
+func (server *ServerStatus) parse_bedrock(pong []byte) (Status_code, error) {
+  // packet ID (1) + time (8) + server GUID (8) + magic (16) + string length (2)
+  if len(pong) < 35 || pong[0] != 0x1C {
+    return RETURN_UNKNOWN, errors.New("invalid unconnected pong")
+  }
+  server_id_length := int(binary.BigEndian.Uint16(pong[33:35]))
+  server_id := pong[35:]
+  // A pong cut short by the read buffer would otherwise yield wrong trailing fields.
+  if len(server_id) < server_id_length {
+    return RETURN_UNKNOWN, fmt.Errorf("truncated unconnected pong: expected %d bytes, got %d", server_id_length, len(server_id))
+  }
+  server_id = server_id[:server_id_length]
+
+  data := strings.Split(string(server_id), ";")
+  if server.Capture_fields {
+    server.Fields = data
+  }
+  if len(data) < NUM_FIELDS_BEDROCK {
+    return RETURN_UNKNOWN, fmt.Errorf("expected at least %d fields, got %d", NUM_FIELDS_BEDROCK, len(data))
+  }
+  current_players, err := parse_player_count(data[4])
+  if err != nil {
+    return RETURN_UNKNOWN, server.field_error("current_players", err)
+  }
+  max_players, err := parse_player_count(data[5])
+  if err != nil {
+    return RETURN_UNKNOWN, server.field_error("max_players", err)
   }
 
-  data := strings.Split(string(raw_data[:]), "\x00\x00\x00")
-  if data != nil && len(data) >= NUM_FIELDS {
-    Online = true
-    Version = data[2]
-    Motd = data[3]
-    Current_players = data[4]
-    Max_players = data[5]
+  server.Protocol = "Bedrock/Pocket Edition"
+  server.Edition = "Bedrock"
+  server.Bedrock_edition = data[0]
+  server.Server_guid = binary.BigEndian.Uint64(pong[9:17])
+  server.Motd = data[1]
+  // Version keeps the edition (and world name below) for compatibility, Version_name is the number alone.
+  server.Version = data[3] + " (" + data[0] + ")"
+  server.Version_name = data[3]
+  protocol_version, err := strconv.Atoi(data[2])
+  if err == nil {
+    server.Protocol_version = protocol_version
   } else {
-    Online = false
+    server.field_error("protocol_version", err)
+  }
+  server.Players.Online = current_players
+  server.Players.Max = max_players
+  if len(data) > 6 {
+    server.Server_id = data[6]
+  }
+  // The second MOTD line, often the world name. Its formatting codes are handled like the first line's.
+  if len(data) > 7 {
+    server.Version = data[3] + " " + data[7] + " (" + data[0] + ")"
+    if data[7] != "" {
+      server.Motd += "\n" + data[7]
+    }
+  }
+  if len(data) > 8 {
+    server.Game_mode = data[8]
+  }
+  // Older servers stop after the game mode; malformed numbers are skipped rather than failing the query.
+  if len(data) > 9 {
+    game_mode_id, err := strconv.Atoi(data[9])
+    if err == nil {
+      server.Game_mode_id = game_mode_id
+    } else if data[9] != "" {
+      server.field_error("game_mode_id", err)
+    }
+  }
+  if len(data) > 10 {
+    port_ipv4, err := strconv.ParseUint(data[10], 10, 16)
+    if err == nil {
+      server.Port_ipv4 = uint16(port_ipv4)
+    } else if data[10] != "" {
+      server.field_error("port_ipv4", err)
+    }
+  }
+  if len(data) > 11 {
+    port_ipv6, err := strconv.ParseUint(data[11], 10, 16)
+    if err == nil {
+      server.Port_ipv6 = uint16(port_ipv6)
+    } else if data[11] != "" {
+      server.field_error("port_ipv6", err)
+    }
+  }
+  return RETURN_SUCCESS, nil
+}
+
+// Parses a player count of the legacy, beta, Bedrock and Query responses.
+func parse_player_count(text string) (uint32, error) {
+  count, err := strconv.ParseUint(text, 10, 32)
+  return uint32(count), err
+}
+
+// Converts a player count of the JSON status response, where some servers send negative numbers.
+func player_count(count int) uint32 {
+  if count < 0 {
+    return 0
+  }
+  return uint32(count)
+}
+
+/* Removes ANSI escape sequences, control characters other than newlines and bidirectional
+   text overrides from a string, so that text sent by a server, such as its MOTD, cannot
+   move the cursor, recolor or retitle a terminal, or disguise itself when printed or logged.
+   "§" formatting codes are left alone, see StripFormatting() for those. */
+func SanitizeForTerminal(text string) string {
+  var sanitized strings.Builder
+  runes := []rune(text)
+  for i := 0; i < len(runes); i++ {
+    character := runes[i]
+    switch {
+    case character == '\x1B' && i + 1 < len(runes) && runes[i + 1] == '[':
+      // CSI: parameters and intermediates up to a final byte in @ to ~
+      for i += 2; i < len(runes) && (runes[i] < '@' || runes[i] > '~'); i++ {
+      }
+    case character == '\x1B' && i + 1 < len(runes) && runes[i + 1] == ']':
+      // OSC (e.g. window titles and hyperlinks): up to BEL or ESC \
+      for i += 2; i < len(runes) && runes[i] != '\a'; i++ {
+        if runes[i] == '\x1B' && i + 1 < len(runes) && runes[i + 1] == '\\' {
+          i++
+          break
+        }
+      }
+    case character == '\x1B':
+      // Any other escape sequence is ESC and one more character.
+      i++
+    case character == '\n':
+      sanitized.WriteRune(character)
+    case unicode.IsControl(character):
+    case character >= '\u202A' && character <= '\u202E', character >= '\u2066' && character <= '\u2069':
+    default:
+      sanitized.WriteRune(character)
+    }
+  }
+  return sanitized.String()
+}
+
+// Removes "§" formatting codes (colors and styles) from a string.
+func StripFormatting(text string) string {
+  var stripped strings.Builder
+  formatting_code := false
+  for _, character := range text {
+    switch {
+    case formatting_code:
+      formatting_code = false
+    case character == '§':
+      formatting_code = true
+    default:
+      stripped.WriteRune(character)
+    }
+  }
+  return stripped.String()
+}
+
+/* Plain text of a JSON chat component. Servers send the description as a plain
+   string, as an object with just "text" or as a full component with "extra"
+   children, so it cannot be unmarshaled into a string or a fixed struct. */
+type chat_text struct {
+  text string
+  components []MotdComponent
+}
+
+func (text *chat_text) UnmarshalJSON(data []byte) error {
+  var component interface{}
+  err := json.Unmarshal(data, &component)
+  if err != nil {
+    return err
+  }
+  text.text = flatten_chat(component)
+  text.components = chat_components(component, MotdComponent{})
+  return nil
+}
+
+/* Flattens a JSON chat component into plain text. A component is either a
+   string, an array of components or an object with "text" (or "translate"
+   and its "with" arguments) followed by its "extra" children.
+   Legacy "§" codes inside the text are kept. */
+func flatten_chat(component interface{}) string {
+  switch component := component.(type) {
+  case string:
+    return component
+  case []interface{}:
+    var text strings.Builder
+    for _, child := range component {
+      text.WriteString(flatten_chat(child))
+    }
+    return text.String()
+  case map[string]interface{}:
+    text := component_text(component)
+    if extra, ok := component["extra"].([]interface{}); ok {
+      text += flatten_chat(extra)
+    }
+    return text
+  }
+  return ""
+}
+
+// Text of a chat component object itself, without its "extra" children.
+func component_text(component map[string]interface{}) string {
+  text, _ := component["text"].(string)
+  if translate, ok := component["translate"].(string); ok && text == "" {
+    // There is no translation table here, so show the key with its arguments filled in.
+    text = translate
+    arguments, _ := component["with"].([]interface{})
+    for i, argument := range arguments {
+      flat_argument := flatten_chat(argument)
+      text = strings.Replace(text, fmt.Sprintf("%%%d$s", i + 1), flat_argument, -1)
+      text = strings.Replace(text, "%s", flat_argument, 1)
+    }
+  }
+  return text
+}
+
+// Decodes a UTF-16BE byte slice as used by the legacy protocols.
+func decode_utf16be(raw_data []byte) string {
+  characters := make([]uint16, len(raw_data) / 2)
+  for i := range characters {
+    characters[i] = binary.BigEndian.Uint16(raw_data[i * 2:])
   }
+  return string(utf16.Decode(characters))
 }
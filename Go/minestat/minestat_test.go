@@ -0,0 +1,963 @@
+/* Unit tests for minestat.go */
+
+package minestat
+
+import "bufio"
+import "bytes"
+import "context"
+import "crypto/tls"
+import "encoding/binary"
+import "encoding/json"
+import "errors"
+import "fmt"
+import "io"
+import "net"
+import "net/http/httptest"
+import "os"
+import "strings"
+import "syscall"
+import "testing"
+import "time"
+import "unicode/utf16"
+
+// Builds an unconnected pong carrying the given server ID string.
+func bedrock_pong(server_id string) []byte {
+  var pong bytes.Buffer
+  pong.WriteByte(0x1C)
+  binary.Write(&pong, binary.BigEndian, int64(0))
+  binary.Write(&pong, binary.BigEndian, uint64(0x1234))
+  pong.Write([]byte("\x00\xFF\xFF\x00\xFE\xFE\xFE\xFE\xFD\xFD\xFD\xFD\x12\x34\x56\x78"))
+  binary.Write(&pong, binary.BigEndian, uint16(len(server_id)))
+  pong.WriteString(server_id)
+  return pong.Bytes()
+}
+
+func TestParseBedrockShortPong(t *testing.T) {
+  full_pong := bedrock_pong("MCPE;A Bedrock Server;594;1.20.12;3;10;")
+  pongs := map[string][]byte{
+    "empty": {},
+    "packet ID only": {0x1C},
+    "truncated header": full_pong[:34],
+    "no server ID": bedrock_pong(""),
+    "too few fields": bedrock_pong("MCPE;A Bedrock Server;594;1.20.12"),
+    "invalid player count": bedrock_pong("MCPE;A Bedrock Server;594;1.20.12;three;10;"),
+    "wrong packet ID": append([]byte{0x1D}, full_pong[1:]...),
+    "truncated server ID": full_pong[:len(full_pong) - 5],
+  }
+  for name, pong := range pongs {
+    server := NewServer("localhost")
+    retval, err := server.parse_bedrock(pong)
+    if retval != RETURN_UNKNOWN || err == nil {
+      t.Errorf("%s: got (%s, %v), want RETURN_UNKNOWN with an error", name, retval, err)
+    }
+  }
+
+  server := NewServer("localhost")
+  server.parse_bedrock(pongs["invalid player count"])
+  if len(server.Parse_errors) != 1 || !strings.HasPrefix(server.Parse_errors[0], "current_players: ") {
+    t.Errorf("got parse errors %q, want one for current_players", server.Parse_errors)
+  }
+}
+
+func TestParseBedrockPong(t *testing.T) {
+  server := NewServer("localhost")
+  retval, err := server.parse_bedrock(bedrock_pong("MCPE;A Bedrock Server;594;1.20.12;3;10;13253860892328930865;Bedrock level;Survival;1;19132;19133;"))
+  if retval != RETURN_SUCCESS || err != nil {
+    t.Fatalf("got (%s, %v), want RETURN_SUCCESS", retval, err)
+  }
+  if server.Motd != "A Bedrock Server\nBedrock level" || server.Players.Online != 3 || server.Players.Max != 10 {
+    t.Errorf("got MOTD %q with %d/%d players", server.Motd, server.Players.Online, server.Players.Max)
+  }
+  if server.Server_guid != 0x1234 {
+    t.Errorf("got server GUID %x, want 1234", server.Server_guid)
+  }
+  if server.Bedrock_edition != "MCPE" || server.Version_name != "1.20.12" || server.Protocol_version != 594 {
+    t.Errorf("got edition %q, version %q and protocol %d", server.Bedrock_edition, server.Version_name, server.Protocol_version)
+  }
+  if server.Game_mode != "Survival" || server.Game_mode_id != 1 || server.Port_ipv4 != 19132 || server.Port_ipv6 != 19133 {
+    t.Errorf("got game mode %q (%d) on ports %d/%d", server.Game_mode, server.Game_mode_id, server.Port_ipv4, server.Port_ipv6)
+  }
+
+  // Servers predating the optional fields only send the first six.
+  server = NewServer("localhost")
+  retval, err = server.parse_bedrock(bedrock_pong("MCPE;A Bedrock Server;594;1.20.12;3;10"))
+  if retval != RETURN_SUCCESS || err != nil {
+    t.Fatalf("got (%s, %v), want RETURN_SUCCESS", retval, err)
+  }
+  if server.Game_mode_id != -1 || server.Port_ipv4 != 0 {
+    t.Errorf("got game mode %d and IPv4 port %d for a pong without them", server.Game_mode_id, server.Port_ipv4)
+  }
+}
+
+func TestMarshalJSON(t *testing.T) {
+  server := NewServer("localhost")
+  server.parse_bedrock(bedrock_pong("MCPE;A Bedrock Server;594;1.20.12;3;10;"))
+  server.Online = true
+  server.Latency = 42 * time.Millisecond
+  data, err := json.Marshal(server)
+  if err != nil {
+    t.Fatal(err)
+  }
+  var result map[string]interface{}
+  json.Unmarshal(data, &result)
+  if result["address"] != "localhost" || result["online"] != true || result["latency_ms"] != 42.0 {
+    t.Errorf("got %s", data)
+  }
+  players, _ := result["players"].(map[string]interface{})
+  if players["online"] != 3.0 || players["max"] != 10.0 {
+    t.Errorf("got players %v", result["players"])
+  }
+  if _, ok := result["favicon"]; ok {
+    t.Errorf("got a favicon for a server without one: %s", data)
+  }
+}
+
+func TestUnmarshalDescription(t *testing.T) {
+  descriptions := map[string]string{
+    `"A Minecraft Server"`: "A Minecraft Server",
+    `{"text": "A Minecraft Server"}`: "A Minecraft Server",
+    `{"text": "", "extra": [{"text": "A ", "color": "gold"}, {"text": "Minecraft"}, " Server"]}`: "A Minecraft Server",
+    `null`: "",
+  }
+  for description, motd := range descriptions {
+    var text chat_text
+    err := json.Unmarshal([]byte(description), &text)
+    if err != nil || text.text != motd {
+      t.Errorf("%s: got (%q, %v), want %q", description, text.text, err, motd)
+    }
+  }
+}
+
+func TestParseAddress(t *testing.T) {
+  addresses := map[string]struct {
+    host string
+    port uint16
+  }{
+    "mc.example.com": {"mc.example.com", 25565},
+    "mc.example.com:25566": {"mc.example.com", 25566},
+    " 192.0.2.1:1 ": {"192.0.2.1", 1},
+    "::1": {"::1", 25565},
+    "[::1]": {"::1", 25565},
+    "[2001:db8::1]:25570": {"2001:db8::1", 25570},
+  }
+  for address, want := range addresses {
+    host, port, err := ParseAddress(address)
+    if err != nil || host != want.host || port != want.port {
+      t.Errorf("%q: got (%q, %d, %v), want (%q, %d)", address, host, port, err, want.host, want.port)
+    }
+  }
+  for _, address := range []string{"", ":25565", "mc.example.com:", "mc.example.com:65536", "mc.example.com:0", "mc.example.com:port", "[::1", "1:2:3:x", "mc example com"} {
+    _, _, err := ParseAddress(address)
+    if err == nil {
+      t.Errorf("%q: got no error", address)
+    }
+  }
+  _, port, _ := ParseBedrockAddress("bedrock.example.com")
+  if port != DEFAULT_BEDROCK_PORT {
+    t.Errorf("got Bedrock port %d, want %d", port, DEFAULT_BEDROCK_PORT)
+  }
+}
+
+// Builds a kick packet carrying the given payload.
+func kick_packet(payload string) []byte {
+  characters := utf16.Encode([]rune(payload))
+  var packet bytes.Buffer
+  packet.WriteByte(0xFF)
+  binary.Write(&packet, binary.BigEndian, uint16(len(characters)))
+  binary.Write(&packet, binary.BigEndian, characters)
+  return packet.Bytes()
+}
+
+func TestParseBeta(t *testing.T) {
+  server := NewServer("localhost")
+  retval, err := server.parse_beta(bytes.NewReader(kick_packet("§4A §lBeta§r Server§2§20")))
+  if retval != RETURN_SUCCESS || err != nil {
+    t.Fatalf("got (%s, %v), want RETURN_SUCCESS", retval, err)
+  }
+  if server.Motd != "§4A §lBeta§r Server" || server.Players.Online != 2 || server.Players.Max != 20 {
+    t.Errorf("got MOTD %q with %d/%d players", server.Motd, server.Players.Online, server.Players.Max)
+  }
+
+  server = NewServer("localhost")
+  retval, _ = server.parse_beta(bytes.NewReader(kick_packet("Protocol error")))
+  if retval != RETURN_UNKNOWN {
+    t.Errorf("got %s for a kick message, want RETURN_UNKNOWN", retval)
+  }
+}
+
+/* Answers DNS queries over a net.Pipe() with an A record for every name, so that
+   a resolver using it never touches the network. */
+func stub_dns(ip net.IP) func(ctx context.Context, network string, address string) (net.Conn, error) {
+  return func(ctx context.Context, network string, address string) (net.Conn, error) {
+    client, server := net.Pipe()
+    go func() {
+      defer server.Close()
+      for {
+        // Stream connections frame each message with its length.
+        var length uint16
+        if binary.Read(server, binary.BigEndian, &length) != nil {
+          return
+        }
+        query := make([]byte, length)
+        if _, err := io.ReadFull(server, query); err != nil {
+          return
+        }
+        name_end := 12 + bytes.IndexByte(query[12:], 0) + 1
+        question := query[12:name_end + 4]
+        var response bytes.Buffer
+        response.Write(query[:2]) // ID
+        response.Write([]byte("\x81\x80\x00\x01"))
+        if binary.BigEndian.Uint16(query[name_end:]) == 1 {
+          response.Write([]byte("\x00\x01\x00\x00\x00\x00"))
+          response.Write(question)
+          response.Write([]byte("\xC0\x0C\x00\x01\x00\x01\x00\x00\x00\x3C\x00\x04"))
+          response.Write(ip.To4())
+        } else {
+          // No records of any other type
+          response.Write([]byte("\x00\x00\x00\x00\x00\x00"))
+          response.Write(question)
+        }
+        binary.Write(server, binary.BigEndian, uint16(response.Len()))
+        server.Write(response.Bytes())
+      }
+    }()
+    return client, nil
+  }
+}
+
+func TestWithResolver(t *testing.T) {
+  listener, err := net.Listen("tcp", "127.0.0.1:0")
+  if err != nil {
+    t.Skip(err)
+  }
+  defer listener.Close()
+  go func() {
+    for {
+      conn, err := listener.Accept()
+      if err != nil {
+        return
+      }
+      conn.Close()
+    }
+  }()
+
+  resolver := &net.Resolver{PreferGo: true, Dial: stub_dns(net.IPv4(127, 0, 0, 1))}
+  port := uint16(listener.Addr().(*net.TCPAddr).Port)
+  server := NewServer("minecraft.test", WithPort(port), WithResolver(resolver), WithProtocol(REQUEST_LEGACY), WithTimeout(time.Second))
+  server.Query()
+  // The listener hangs up without answering, so the query fails only after connecting.
+  if server.Connection_status != RETURN_UNKNOWN || server.Connect_failure != FAILURE_NONE {
+    t.Errorf("got status %s and connect failure %d, want to reach the listener", server.Connection_status, server.Connect_failure)
+  }
+}
+
+func TestDecodePayload(t *testing.T) {
+  payloads := map[string][]byte{
+    "UTF-16BE": kick_packet("§1\x0047\x001.4.7\x00A Mïnecraft Server\x000\x0020")[3:],
+    "UTF-8": []byte("§1\x0047\x001.4.7\x00A Mïnecraft Server\x000\x0020"),
+    "Latin-1": []byte("\xA71\x0047\x001.4.7\x00A M\xEFnecraft Server\x000\x0020"),
+  }
+  for name, payload := range payloads {
+    data := decode_payload(payload)
+    if data != "§1\x0047\x001.4.7\x00A Mïnecraft Server\x000\x0020" {
+      t.Errorf("%s: got %q", name, data)
+    }
+  }
+
+  // Non-Latin MOTDs have few NUL high bytes, but are UTF-16BE all the same.
+  for _, data := range []string{
+    "§1\x0047\x001.4.7\x00Добро пожаловать на сервер Майнкрафт\x000\x0020",
+    "§1\x0047\x001.4.7\x00欢迎来到我的世界服务器\x000\x0020",
+    "Сервер Майнкрафт§3§20",
+    "🎮 服务器§3§20",
+  } {
+    if got := decode_payload(kick_packet(data)[3:]); got != data {
+      t.Errorf("got %q, want %q", got, data)
+    }
+  }
+  // An unpaired surrogate is not UTF-16BE.
+  if got := decode_payload([]byte("\xD8\x00AB")); got != "\u00D8\x00AB" {
+    t.Errorf("got %q for an unpaired surrogate, want Latin-1", got)
+  }
+}
+
+func TestParseLegacyNonLatinMotd(t *testing.T) {
+  client, server_conn := net.Pipe()
+  go func() {
+    defer server_conn.Close()
+    io.ReadFull(server_conn, make([]byte, 2))
+    server_conn.Write(kick_packet("§1\x0061\x001.5.2\x00Сервер Майнкрафт\x004\x0016"))
+  }()
+  server, err := ParseFromConn(client, REQUEST_LEGACY)
+  if err != nil || server.Motd != "Сервер Майнкрафт" || server.Players.Online != 4 {
+    t.Errorf("got MOTD %q with %d players (%v)", server.Motd, server.Players.Online, err)
+  }
+}
+
+// Connection that keeps the last deadline set on it.
+type deadline_conn struct {
+  net.Conn
+  deadline time.Time
+}
+
+func (conn *deadline_conn) SetDeadline(deadline time.Time) error {
+  conn.deadline = deadline
+  return conn.Conn.SetDeadline(deadline)
+}
+
+func TestParseFromConn(t *testing.T) {
+  client, server_conn := net.Pipe()
+  defer client.Close()
+  go func() {
+    defer server_conn.Close()
+    request := make([]byte, 2)
+    io.ReadFull(server_conn, request)
+    server_conn.Write(kick_packet("§1\x0061\x001.5.2\x00A Legacy Server\x004\x0016"))
+  }()
+
+  conn := &deadline_conn{Conn: client}
+  server, err := ParseFromConn(conn, REQUEST_LEGACY)
+  if err != nil || !server.Online {
+    t.Fatalf("got (%s, %v), want to be online", server.Connection_status, err)
+  }
+  if !conn.deadline.IsZero() {
+    t.Errorf("got deadline %v on the connection handed back, want none", conn.deadline)
+  }
+  if server.Version != "1.5.2" || server.Motd != "A Legacy Server" || server.Players.Online != 4 || server.Players.Max != 16 {
+    t.Errorf("got version %q, MOTD %q with %d/%d players", server.Version, server.Motd, server.Players.Online, server.Players.Max)
+  }
+
+  for _, protocol := range []uint16{REQUEST_NONE, REQUEST_JAVA} {
+    recording := &recording_conn{Conn: client}
+    _, err = ParseFromConn(recording, protocol)
+    if err == nil || recording.written.Len() != 0 {
+      t.Errorf("got %q written (%v) with protocol %d, want an error before writing anything", recording.written.Bytes(), err, protocol)
+    }
+  }
+}
+
+// Answers a 1.7+ status request on conn with the given JSON length and the JSON in chunks.
+func serve_status(conn net.Conn, json_length int, chunks ...string) {
+  defer conn.Close()
+  reader := bufio.NewReader(conn)
+  for i := 0; i < 2; i++ {
+    length, _ := ReadVarInt(reader)
+    io.CopyN(io.Discard, reader, int64(length))
+  }
+  var header bytes.Buffer
+  var length_varint bytes.Buffer
+  WriteVarInt(&length_varint, json_length)
+  WriteVarInt(&header, 1 + length_varint.Len() + json_length)
+  header.WriteByte(0x00)
+  header.Write(length_varint.Bytes())
+  conn.Write(header.Bytes())
+  for _, chunk := range chunks {
+    conn.Write([]byte(chunk))
+  }
+}
+
+// JSON status of a 1.20.1 server with the given MOTD and number of players online
+func status_json(motd string, online int) string {
+  return fmt.Sprintf(`{"version": {"name": "1.20.1", "protocol": 763}, "players": {"max": 20, "online": %d}, "description": %q}`, online, motd)
+}
+
+// Status of the vanilla server most tests query
+var TEST_STATUS = status_json("A Minecraft Server", 1)
+
+// Returns a dial function answering each connection with the given JSON status.
+func status_dial_func(status string) func(context.Context, string, string) (net.Conn, error) {
+  return func(ctx context.Context, network string, address string) (net.Conn, error) {
+    client, server_conn := net.Pipe()
+    go serve_status(server_conn, len(status), status)
+    return client, nil
+  }
+}
+
+// Answers an unconnected ping on conn with a pong carrying server_id.
+func serve_bedrock(conn net.Conn, server_id string) {
+  defer conn.Close()
+  io.ReadFull(conn, make([]byte, 33))
+  conn.Write(bedrock_pong(server_id))
+}
+
+func TestJSONResponseInChunks(t *testing.T) {
+  client, server_conn := net.Pipe()
+  go serve_status(server_conn, len(TEST_STATUS), TEST_STATUS[:40], TEST_STATUS[40:])
+  server, err := ParseFromConn(client, REQUEST_JSON)
+  if err != nil || server.Protocol_version != 763 || server.Motd != "A Minecraft Server" {
+    t.Errorf("got protocol %d and MOTD %q (%v)", server.Protocol_version, server.Motd, err)
+  }
+}
+
+func TestJSONResponseTooLong(t *testing.T) {
+  client, server_conn := net.Pipe()
+  go serve_status(server_conn, DEFAULT_MAX_RESPONSE_BYTES + 1)
+  server, err := ParseFromConn(client, REQUEST_JSON)
+  if server.Connection_status != RETURN_UNKNOWN || err == nil {
+    t.Errorf("got (%s, %v), want RETURN_UNKNOWN with an error", server.Connection_status, err)
+  }
+}
+
+func TestMaxResponseBytes(t *testing.T) {
+  client, server_conn := net.Pipe()
+  defer client.Close()
+  go func() {
+    defer server_conn.Close()
+    io.ReadFull(server_conn, make([]byte, 2))
+    server_conn.Write(kick_packet("§1\x0061\x001.5.2\x00A Legacy Server\x004\x0016"))
+  }()
+  server, err := ParseFromConn(client, REQUEST_LEGACY, WithMaxResponseBytes(16))
+  if server.Connection_status != RETURN_UNKNOWN || err == nil {
+    t.Errorf("got (%s, %v), want RETURN_UNKNOWN with an error", server.Connection_status, err)
+  }
+}
+
+// Connection that keeps a copy of everything the client writes.
+type recording_conn struct {
+  net.Conn
+  written bytes.Buffer
+}
+
+func (conn *recording_conn) Write(data []byte) (int, error) {
+  conn.written.Write(data)
+  return conn.Conn.Write(data)
+}
+
+func TestWithHandshakeHost(t *testing.T) {
+  client, server_conn := net.Pipe()
+  go serve_status(server_conn, len(TEST_STATUS), TEST_STATUS)
+  recording := &recording_conn{Conn: client}
+  _, err := ParseFromConn(recording, REQUEST_JSON, WithHandshakeHost("play.example.com"))
+  if err != nil || !bytes.Contains(recording.written.Bytes(), []byte("\x10play.example.com")) {
+    t.Errorf("got handshake %q (%v), want the host play.example.com", recording.written.Bytes(), err)
+  }
+}
+
+func TestHandshakeBytes(t *testing.T) {
+  tests := map[int]string{
+    // length, packet ID, protocol -1, address length and address, port 25565, next state 1 (status)
+    -1: "\x18\x00\xff\xff\xff\xff\x0f\x0emc.example.com\x63\xdd\x01",
+    763: "\x15\x00\xfb\x05\x0emc.example.com\x63\xdd\x01",
+  }
+  for protocol_version, handshake := range tests {
+    client, server_conn := net.Pipe()
+    go serve_status(server_conn, len(TEST_STATUS), TEST_STATUS)
+    recording := &recording_conn{Conn: client}
+    server := NewServer("mc.example.com", WithPort(25565), WithProtocolVersion(protocol_version))
+    server.resolve_srv(context.Background())
+    _, _, retval, err := server.read_status(recording)
+    client.Close()
+    // the handshake followed by the empty status request
+    if want := handshake + "\x01\x00"; retval != RETURN_SUCCESS || recording.written.String() != want {
+      t.Errorf("got handshake %q (%v) with protocol %d, want %q", recording.written.Bytes(), err, protocol_version, want)
+    }
+  }
+}
+
+func TestLegacyMarker(t *testing.T) {
+  client, server_conn := net.Pipe()
+  defer client.Close()
+  go func() {
+    defer server_conn.Close()
+    io.ReadFull(server_conn, make([]byte, 2))
+    server_conn.Write(kick_packet("xx\x0061\x001.5.2\x00A Legacy Server\x004\x0016"))
+  }()
+  server, err := ParseFromConn(client, REQUEST_LEGACY)
+  if server.Connection_status != RETURN_UNKNOWN || err == nil {
+    t.Errorf("got (%s, %v), want RETURN_UNKNOWN with an error", server.Connection_status, err)
+  }
+}
+
+// Logger that keeps every message.
+type recording_logger struct {
+  debug []string
+  warn []string
+}
+
+func (logger *recording_logger) Debugf(format string, args ...interface{}) {
+  logger.debug = append(logger.debug, fmt.Sprintf(format, args...))
+}
+
+func (logger *recording_logger) Warnf(format string, args ...interface{}) {
+  logger.warn = append(logger.warn, fmt.Sprintf(format, args...))
+}
+
+func TestWithLogger(t *testing.T) {
+  client, server_conn := net.Pipe()
+  defer client.Close()
+  go func() {
+    defer server_conn.Close()
+    io.ReadFull(server_conn, make([]byte, 2))
+    server_conn.Write(kick_packet("§1\x0061\x001.5.2\x00A Legacy Server\x00many\x0016"))
+  }()
+  logger := &recording_logger{}
+  ParseFromConn(client, REQUEST_LEGACY, WithLogger(logger))
+  if len(logger.debug) == 0 || !strings.Contains(logger.debug[0], "legacy") {
+    t.Errorf("got debug messages %q, want the legacy protocol to be tried first", logger.debug)
+  }
+  if len(logger.warn) != 1 || !strings.Contains(logger.warn[0], "current_players") {
+    t.Errorf("got warnings %q, want one about current_players", logger.warn)
+  }
+}
+
+func TestWithMotdSamples(t *testing.T) {
+  motds := []string{"Welcome!", "Now with minigames", "Welcome!"}
+  dials := 0
+  dial_func := func(ctx context.Context, network string, address string) (net.Conn, error) {
+    client, server_conn := net.Pipe()
+    status := status_json(motds[dials % len(motds)], 1)
+    dials++
+    go serve_status(server_conn, len(status), status)
+    return client, nil
+  }
+  server := NewServer("127.0.0.1", WithPort(25565), WithProtocol(REQUEST_JSON), WithDialFunc(dial_func), WithMotdSamples(3))
+  err := server.Query()
+  if err != nil || !server.Motd_dynamic || len(server.Motd_variants) != 2 || server.Motd != "Welcome!" {
+    t.Errorf("got MOTD %q, variants %q (%v), want it to be dynamic", server.Motd, server.Motd_variants, err)
+  }
+}
+
+func TestBedrockMotdFormatting(t *testing.T) {
+  client, server_conn := net.Pipe()
+  defer client.Close()
+  go serve_bedrock(server_conn, "MCPE;§bA §lBedrock§r Server;594;1.20.12;3;10;13253860892328930865;§aBedrock level;Survival;1;19132;19133;")
+  server, err := ParseFromConn(client, REQUEST_BEDROCK)
+  if err != nil || server.Motd_clean != "A Bedrock Server\nBedrock level" {
+    t.Errorf("got clean MOTD %q (%v)", server.Motd_clean, err)
+  }
+  // The test pong echoes 0 rather than the time sent.
+  if !server.Timestamp_mismatch {
+    t.Error("got no timestamp mismatch for a pong echoing the wrong time")
+  }
+  if len(server.Motd_components) == 0 || server.Motd_components[0].Color != "aqua" {
+    t.Errorf("got MOTD components %+v, want the first to be aqua", server.Motd_components)
+  }
+}
+
+func TestWithTLS(t *testing.T) {
+  // Borrow the self-signed certificate of httptest.
+  https := httptest.NewTLSServer(nil)
+  certificates := https.TLS.Certificates
+  https.Close()
+  listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: certificates})
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer listener.Close()
+  status := status_json("A Tunneled Server", 1)
+  go func() {
+    conn, err := listener.Accept()
+    if err == nil {
+      serve_status(conn, len(status), status)
+    }
+  }()
+
+  port := uint16(listener.Addr().(*net.TCPAddr).Port)
+  server := NewServer("127.0.0.1", WithPort(port), WithProtocol(REQUEST_JSON), WithTLS(&tls.Config{InsecureSkipVerify: true}))
+  err = server.Query()
+  if err != nil || server.Motd != "A Tunneled Server" {
+    t.Errorf("got MOTD %q (%v)", server.Motd, err)
+  }
+}
+
+func TestWithProtocolIsStrict(t *testing.T) {
+  dials := 0
+  dial_func := func(ctx context.Context, network string, address string) (net.Conn, error) {
+    dials++
+    // A server that hangs up right away, which is no reason to give up on the other protocols.
+    client, server_conn := net.Pipe()
+    server_conn.Close()
+    return client, nil
+  }
+  server := NewServer("127.0.0.1", WithPort(25565), WithProtocol(REQUEST_JSON), WithDialFunc(dial_func))
+  server.Query()
+  if dials != 1 {
+    t.Errorf("got %d connections with REQUEST_JSON, want 1", dials)
+  }
+
+  dials = 0
+  server = NewServer("127.0.0.1", WithPort(25565), WithProtocol(REQUEST_JAVA), WithDialFunc(dial_func))
+  server.Query()
+  if dials != 4 {
+    t.Errorf("got %d connections with REQUEST_JAVA, want one per Java Edition protocol", dials)
+  }
+}
+
+func TestWithProtocolOrder(t *testing.T) {
+  dial_func := func(ctx context.Context, network string, address string) (net.Conn, error) {
+    client, server_conn := net.Pipe()
+    server_conn.Close()
+    return client, nil
+  }
+  logger := &recording_logger{}
+  server := NewServer("127.0.0.1", WithPort(25565), WithDialFunc(dial_func), WithLogger(logger), WithProtocolOrder([]uint16{REQUEST_BETA, REQUEST_LEGACY}))
+  server.Query()
+  var tried []string
+  for _, message := range logger.debug {
+    if strings.Contains(message, "trying") {
+      tried = append(tried, message)
+    }
+  }
+  if len(tried) != 2 || !strings.Contains(tried[0], "beta") || !strings.Contains(tried[1], "legacy") {
+    t.Errorf("got %q, want the beta and legacy protocols in that order", tried)
+  }
+}
+
+func TestAutoDetectionTriesJSONFirst(t *testing.T) {
+  logger := &recording_logger{}
+  server := NewServer("127.0.0.1", WithPort(25565), WithDialFunc(status_dial_func(TEST_STATUS)), WithLogger(logger))
+  err := server.Query()
+  // Any protocol tried before JSON would fail on the JSON status and show up here too.
+  var tried []string
+  for _, message := range logger.debug {
+    if strings.Contains(message, ": trying the ") {
+      tried = append(tried, message)
+    }
+  }
+  if err != nil || server.Protocol != "SLP 1.7 (JSON)" || len(tried) != 1 || !strings.HasSuffix(tried[0], "trying the JSON protocol") {
+    t.Errorf("got protocol %q after trying %q (%v), want only the JSON protocol tried", server.Protocol, tried, err)
+  }
+}
+
+func TestLocalAddr(t *testing.T) {
+  addr := &net.TCPAddr{IP: net.ParseIP("192.0.2.1")}
+  if udp_addr, ok := local_addr("udp", addr).(*net.UDPAddr); !ok || !udp_addr.IP.Equal(addr.IP) {
+    t.Errorf("got %#v for UDP, want a *net.UDPAddr with the same IP", local_addr("udp", addr))
+  }
+  if local_addr("tcp", addr) != addr {
+    t.Errorf("got %#v for TCP, want the address unchanged", local_addr("tcp", addr))
+  }
+}
+
+func TestMotdTruncated(t *testing.T) {
+  client, server_conn := net.Pipe()
+  defer client.Close()
+  go func() {
+    defer server_conn.Close()
+    io.ReadFull(server_conn, make([]byte, 2))
+    server_conn.Write(kick_packet("§1\x0061\x001.5.2\x00A Legacy Server §\x004\x0016"))
+  }()
+  server, err := ParseFromConn(client, REQUEST_LEGACY)
+  if err != nil || !server.Motd_truncated || server.Motd_length != 18 {
+    t.Errorf("got truncated %t with length %d (%v), want a truncated MOTD of 18 bytes", server.Motd_truncated, server.Motd_length, err)
+  }
+}
+
+func TestLegacyExtraFields(t *testing.T) {
+  client, server_conn := net.Pipe()
+  defer client.Close()
+  go func() {
+    defer server_conn.Close()
+    io.ReadFull(server_conn, make([]byte, 2))
+    server_conn.Write(kick_packet("§1\x0061\x001.5.2\x00custom\x00A Legacy Server\x004\x0016"))
+  }()
+  server, err := ParseFromConn(client, REQUEST_LEGACY)
+  if err != nil || server.Version != "1.5.2\x00custom" || server.Motd != "A Legacy Server" || server.Players.Online != 4 || server.Players.Max != 16 {
+    t.Errorf("got version %q, MOTD %q with %d/%d players (%v)", server.Version, server.Motd, server.Players.Online, server.Players.Max, err)
+  }
+}
+
+func TestWithPingServerHangsUp(t *testing.T) {
+  client, server_conn := net.Pipe()
+  // serve_status hangs up right after the status response.
+  go serve_status(server_conn, len(TEST_STATUS), TEST_STATUS)
+  server, err := ParseFromConn(client, REQUEST_JSON, WithPing(true))
+  if err != nil || !server.Online || server.Ping_latency != 0 {
+    t.Errorf("got online %t with ping latency %s (%v), want online without a ping latency", server.Online, server.Ping_latency, err)
+  }
+}
+
+func TestQueryHybrid(t *testing.T) {
+  java_dial_func := status_dial_func(status_json("A Geyser Server", 5))
+  dial_func := func(ctx context.Context, network string, address string) (net.Conn, error) {
+    if network != "udp" {
+      return java_dial_func(ctx, network, address)
+    }
+    client, server_conn := net.Pipe()
+    go serve_bedrock(server_conn, "MCPE;A Geyser Server;594;1.20.12;2;10;13253860892328930865;Geyser;Survival;1;19132;19133;")
+    return client, nil
+  }
+  server, err := QueryHybrid(context.Background(), "127.0.0.1", 0, WithDialFunc(dial_func))
+  if err != nil || !server.Is_hybrid || server.Edition != "Java" {
+    t.Fatalf("got hybrid %t, edition %q (%v), want a hybrid Java result", server.Is_hybrid, server.Edition, err)
+  }
+  if server.Players.Online != 5 || server.Bedrock_players.Online != 2 || server.Bedrock_players.Max != 10 {
+    t.Errorf("got %d Java players and %d/%d Bedrock players", server.Players.Online, server.Bedrock_players.Online, server.Bedrock_players.Max)
+  }
+}
+
+func TestSanitizeForTerminal(t *testing.T) {
+  tests := map[string]string{
+    "A Minecraft Server": "A Minecraft Server",
+    "Line one\nLine two": "Line one\nLine two",
+    "\x1b[2J\x1b[31mRed\x1b[0m": "Red",
+    "\x1b]0;pwned\x07Title": "Title",
+    "\x1b]8;;http://example.com\x1b\\Link": "Link",
+    "Bell\x07 and\r return\x00": "Bell and return",
+    "‮gnp.exe": "gnp.exe",
+    "§aGreen": "§aGreen",
+  }
+  for text, want := range tests {
+    if got := SanitizeForTerminal(text); got != want {
+      t.Errorf("SanitizeForTerminal(%q) = %q, want %q", text, got, want)
+    }
+  }
+}
+
+func TestTimings(t *testing.T) {
+  client, server_conn := net.Pipe()
+  defer client.Close()
+  go func() {
+    defer server_conn.Close()
+    io.ReadFull(server_conn, make([]byte, 2))
+    time.Sleep(10 * time.Millisecond)
+    server_conn.Write(kick_packet("§1\x0061\x001.5.2\x00A Legacy Server\x004\x0016"))
+  }()
+  server, err := ParseFromConn(client, REQUEST_LEGACY)
+  if err != nil || server.Timings.Ping < 10 * time.Millisecond || server.Timings.Connect != 0 {
+    t.Errorf("got timings %+v (%v), want a ping of at least 10ms and no connect", server.Timings, err)
+  }
+}
+
+func TestTransportMismatch(t *testing.T) {
+  servers := []*ServerStatus{
+    NewServer("127.0.0.1", WithProtocol(REQUEST_BEDROCK), WithTLS(&tls.Config{})),
+    NewServer("127.0.0.1", WithProtocol(REQUEST_QUERY), WithHandshakeHost("play.example.com")),
+    NewServer("127.0.0.1", WithProtocol(REQUEST_JSON), WithClientGUID(42)),
+  }
+  for _, server := range servers {
+    err := server.Query()
+    if server.Connection_status != RETURN_UNKNOWN || err == nil {
+      t.Errorf("got (%s, %v) for request type %d, want RETURN_UNKNOWN with an error", server.Connection_status, err, server.Request_type)
+    }
+  }
+
+  // With several options that do not apply, the same one is always reported.
+  for i := 0; i < 10; i++ {
+    server := NewServer("127.0.0.1", WithProtocol(REQUEST_BEDROCK), WithTLS(&tls.Config{}), WithHandshakeHost("play.example.com"), WithPing(true))
+    err := server.Query()
+    if err == nil || err.Error() != "WithTLS() only applies to TCP, but Bedrock uses UDP" {
+      t.Fatalf("got %v, want the error about WithTLS()", err)
+    }
+  }
+}
+
+func TestWithRaknetMagic(t *testing.T) {
+  magic := []byte("custom raknet fk")
+  client, server_conn := net.Pipe()
+  defer client.Close()
+  received := make(chan []byte, 1)
+  go func() {
+    defer server_conn.Close()
+    ping := make([]byte, 33)
+    io.ReadFull(server_conn, ping)
+    received <- ping[9:25]
+    server_conn.Write(bedrock_pong("MCPE;A Bedrock Server;594;1.20.12;3;10;"))
+  }()
+  _, err := ParseFromConn(client, REQUEST_BEDROCK, WithRaknetMagic(magic))
+  if sent := <-received; err != nil || !bytes.Equal(sent, magic) {
+    t.Errorf("got magic %q (%v), want %q", sent, err, magic)
+  }
+
+  server := NewServer("127.0.0.1", WithProtocol(REQUEST_BEDROCK), WithRaknetMagic([]byte("short")))
+  if err := server.Query(); err == nil {
+    t.Error("got no error for a magic of 5 bytes")
+  }
+}
+
+func TestQueryTwice(t *testing.T) {
+  online := true
+  dial_func := func(ctx context.Context, network string, address string) (net.Conn, error) {
+    if !online {
+      return nil, errors.New("connection refused")
+    }
+    client, server_conn := net.Pipe()
+    status := `{"version": {"name": "1.12.2", "protocol": 340}, "players": {"max": 20, "online": 1}, "description": "A Forge Server", "modinfo": {"type": "FML", "modList": [{"modid": "mcp", "version": "9.42"}]}}`
+    go serve_status(server_conn, len(status), status)
+    return client, nil
+  }
+  server := NewServer("127.0.0.1", WithPort(25565), WithProtocol(REQUEST_JSON), WithDialFunc(dial_func))
+  server.Query()
+  server.Query()
+  if !server.Online || len(server.Mods) != 1 {
+    t.Errorf("got mods %v after querying twice, want one", server.Mods)
+  }
+
+  online = false
+  server.Query()
+  if server.Online || server.Version != "" || server.Motd != "" || server.Players.Max != 0 || server.Mods != nil || server.Protocol_version != -1 {
+    t.Errorf("got version %q, MOTD %q, %d max players and mods %v once offline", server.Version, server.Motd, server.Players.Max, server.Mods)
+  }
+}
+
+func TestCacheChallengeEvictsExpired(t *testing.T) {
+  query_challenges_lock.Lock()
+  query_challenges["127.0.0.1:25565"] = query_challenge{1, time.Now().Add(-QUERY_CHALLENGE_TTL)}
+  query_challenges_lock.Unlock()
+  cache_challenge("127.0.0.2:25565", 2)
+  defer forget_challenge("127.0.0.2:25565")
+
+  query_challenges_lock.Lock()
+  defer query_challenges_lock.Unlock()
+  if _, ok := query_challenges["127.0.0.1:25565"]; ok {
+    t.Error("expired challenge token kept after caching another")
+  }
+  if challenge := query_challenges["127.0.0.2:25565"]; challenge.token != 2 {
+    t.Errorf("got cached token %d, want 2", challenge.token)
+  }
+}
+
+func TestCachedQueryEvictsOldest(t *testing.T) {
+  defer ClearCache()
+  now := time.Now()
+  cache_lock.Lock()
+  for i := 0; i < MAX_CACHE_ENTRIES; i++ {
+    cache[fmt.Sprintf("filler%d", i)] = cache_entry{NewServer("filler"), nil, now}
+  }
+  cache["filler0"] = cache_entry{NewServer("filler"), nil, now.Add(-time.Minute)}
+  cache_lock.Unlock()
+
+  dial_func := func(ctx context.Context, network string, address string) (net.Conn, error) {
+    return nil, errors.New("connection refused")
+  }
+  CachedQuery("127.0.0.1", time.Minute, WithPort(25565), WithDialFunc(dial_func))
+
+  cache_lock.Lock()
+  defer cache_lock.Unlock()
+  if len(cache) != MAX_CACHE_ENTRIES {
+    t.Errorf("got %d cached results, want %d", len(cache), MAX_CACHE_ENTRIES)
+  }
+  if _, ok := cache["filler0"]; ok {
+    t.Error("oldest result kept after the cache filled up")
+  }
+}
+
+func TestDetectProxyFallback(t *testing.T) {
+  tests := []struct {
+    version string
+    motd string
+    want bool
+  }{
+    {"BungeeCord 1.8.x-1.20.x", "§cCould not connect to a default or fallback server", true},
+    {"Velocity 3.3.0", "Unknown host", true},
+    {"TCPShield.com", "§4Server not found.", true},
+    {"1.20.4", "Invalid hostname", true},
+    {"BungeeCord 1.8.x-1.20.x", "Another Bungee server", false},
+    {"Velocity 3.3.0", "A Velocity Server", false},
+    {"Waterfall 1.20", "§aWelcome to the network!", false},
+    {"1.20.4", "A Minecraft Server", false},
+  }
+  for _, test := range tests {
+    server := NewServer("127.0.0.1")
+    server.Version, server.Motd = test.version, test.motd
+    server.detect_proxy_fallback()
+    if server.Is_proxy_fallback != test.want {
+      t.Errorf("got Is_proxy_fallback %t for version %q and MOTD %q, want %t", server.Is_proxy_fallback, test.version, test.motd, test.want)
+    }
+  }
+}
+
+func TestReadKickShortPayload(t *testing.T) {
+  latin1 := []byte("\xA71\x0047\x001.4.7\x00A M\xEFnecraft Server\x000\x0020")
+  header := []byte{0xFF, 0x00, byte(len(latin1))}
+  server := NewServer("127.0.0.1")
+  payload, retval, err := server.read_kick(bytes.NewReader(append(header, latin1...)))
+  if retval != RETURN_SUCCESS || payload != "§1\x0047\x001.4.7\x00A Mïnecraft Server\x000\x0020" {
+    t.Errorf("got %q (%v) for a single-byte payload", payload, err)
+  }
+
+  // UTF-16BE cut short halfway by the connection dropping
+  utf16 := kick_packet("§1\x0047\x001.4.7\x00A Server\x000\x0020")
+  _, retval, _ = server.read_kick(bytes.NewReader(utf16[:3 + (len(utf16) - 3) / 2]))
+  if retval == RETURN_SUCCESS {
+    t.Error("truncated UTF-16BE payload accepted")
+  }
+
+  // Neither all of the payload nor one byte per character
+  _, retval, _ = server.read_kick(bytes.NewReader(append(header, latin1[:10]...)))
+  if retval == RETURN_SUCCESS {
+    t.Error("truncated single-byte payload accepted")
+  }
+}
+
+func TestPingSamplesInvalidCount(t *testing.T) {
+  for _, count := range []int{0, -1} {
+    samples, err := PingSamples("127.0.0.1", count)
+    if err == nil || samples != nil {
+      t.Errorf("got samples %v and no error for a count of %d", samples, count)
+    }
+  }
+}
+
+func TestWithPortSkipsBedrock(t *testing.T) {
+  // Only a Bedrock ping on the default Bedrock port gets an answer.
+  dial_func := func(ctx context.Context, network string, address string) (net.Conn, error) {
+    if network != "udp" || address != "127.0.0.1:19132" {
+      return nil, errors.New("connection refused")
+    }
+    client, server_conn := net.Pipe()
+    go serve_bedrock(server_conn, "MCPE;A Bedrock Server;594;1.20.12;2;10;13253860892328930865;Bedrock level;Survival;1;19132;19133;")
+    return client, nil
+  }
+  queries := map[string]func(context.Context, string, ...Option) (*ServerStatus, error){
+    "QueryAnyEdition": QueryAnyEdition,
+    "QueryAll": QueryAll,
+  }
+  for name, query := range queries {
+    server, err := query(context.Background(), "127.0.0.1", WithPort(25565), WithDialFunc(dial_func))
+    if !server.Online || server.Edition != "Bedrock" {
+      t.Errorf("%s: got online %t, edition %q (%v), want Bedrock Edition pinged on port 19132", name, server.Online, server.Edition, err)
+    }
+  }
+}
+
+func TestAutoDetectionStopsAfterConnectFailure(t *testing.T) {
+  tests := map[string]struct {
+    err error
+    retval Status_code
+    failure Connect_failure
+  }{
+    "refused": {syscall.ECONNREFUSED, RETURN_CONNFAIL, FAILURE_REFUSED},
+    "blackholed": {os.ErrDeadlineExceeded, RETURN_TIMEOUT, FAILURE_TIMEOUT},
+  }
+  for name, test := range tests {
+    tcp_dials, udp_dials := 0, 0
+    dial_func := func(ctx context.Context, network string, address string) (net.Conn, error) {
+      if network == "udp" {
+        udp_dials++
+        return nil, &net.OpError{Op: "dial", Net: network, Err: syscall.ENETUNREACH}
+      }
+      tcp_dials++
+      return nil, &net.OpError{Op: "dial", Net: network, Err: test.err}
+    }
+    server := NewServer("127.0.0.1", WithDialFunc(dial_func), WithRetries(2, 0))
+    server.Query()
+    // One TCP and one UDP dial per attempt, and only a timeout is retried.
+    attempts := 1
+    if test.retval == RETURN_TIMEOUT {
+      attempts = 3
+    }
+    if server.Attempts != attempts || tcp_dials != attempts || udp_dials != attempts {
+      t.Errorf("%s: got %d TCP and %d UDP dials over %d attempts, want one of each over %d", name, tcp_dials, udp_dials, server.Attempts, attempts)
+    }
+    if server.Connection_status != test.retval || server.Connect_failure != test.failure {
+      t.Errorf("%s: got %s (%v), want %s (%v)", name, server.Connection_status, server.Connect_failure, test.retval, test.failure)
+    }
+  }
+}
+
+func TestCachedQueryCopiesResults(t *testing.T) {
+  defer ClearCache()
+  opts := []Option{WithPort(25565), WithProtocol(REQUEST_JSON), WithDialFunc(status_dial_func(TEST_STATUS))}
+  first, err := CachedQuery("127.0.0.1", time.Minute, opts...)
+  if err != nil || len(first.Motd_components) == 0 {
+    t.Fatalf("got MOTD components %+v (%v)", first.Motd_components, err)
+  }
+  first.Motd_components[0].Text = "Changed"
+  second, _ := CachedQuery("127.0.0.1", time.Minute, opts...)
+  second.Motd_components = append(second.Motd_components[:0], MotdComponent{Text: "Changed too"})
+  third, _ := CachedQuery("127.0.0.1", time.Minute, opts...)
+  if third.Motd_components[0].Text != "A Minecraft Server" {
+    t.Errorf("got cached MOTD component %q, want it unchanged by callers", third.Motd_components[0].Text)
+  }
+}
@@ -0,0 +1,130 @@
+/*
+ * motd.go - Structured MOTD with colors and styles
+ * Copyright (C) 2016 Lloyd Dilley
+ * http://www.dilley.me/
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package minestat
+
+import "strings"
+
+// Run of MOTD text sharing the same color and style
+type MotdComponent struct {
+  Text string `json:"text"`
+  Color string `json:"color,omitempty"` // color name such as "gold" or "#RRGGBB", empty for the default
+  Bold bool `json:"bold,omitempty"`
+  Italic bool `json:"italic,omitempty"`
+  Underline bool `json:"underlined,omitempty"`
+  Strikethrough bool `json:"strikethrough,omitempty"`
+  Obfuscated bool `json:"obfuscated,omitempty"`
+}
+
+// Names of the "§0" to "§f" color codes as used in JSON chat components
+var legacy_colors = map[rune]string{
+  '0': "black", '1': "dark_blue", '2': "dark_green", '3': "dark_aqua",
+  '4': "dark_red", '5': "dark_purple", '6': "gold", '7': "gray",
+  '8': "dark_gray", '9': "blue", 'a': "green", 'b': "aqua",
+  'c': "red", 'd': "light_purple", 'e': "yellow", 'f': "white",
+}
+
+/* Splits a JSON chat component into runs of text. Children inherit the style
+   of their parent, and "§" codes inside the text are applied on top of it. */
+func chat_components(component interface{}, style MotdComponent) []MotdComponent {
+  switch component := component.(type) {
+  case string:
+    return legacy_components(component, style)
+  case []interface{}:
+    var components []MotdComponent
+    for _, child := range component {
+      components = append(components, chat_components(child, style)...)
+    }
+    return components
+  case map[string]interface{}:
+    if color, ok := component["color"].(string); ok {
+      style.Color = color
+    }
+    for key, value := range map[string]*bool{
+      "bold": &style.Bold,
+      "italic": &style.Italic,
+      "underlined": &style.Underline,
+      "strikethrough": &style.Strikethrough,
+      "obfuscated": &style.Obfuscated,
+    } {
+      if set, ok := component[key].(bool); ok {
+        *value = set
+      }
+    }
+    components := legacy_components(component_text(component), style)
+    if extra, ok := component["extra"].([]interface{}); ok {
+      components = append(components, chat_components(extra, style)...)
+    }
+    return components
+  }
+  return nil
+}
+
+/* Splits text with "§" codes into runs of text. A color code resets the styles
+   like it does in game, and "§r" resets to the default look. */
+func legacy_components(text string, style MotdComponent) []MotdComponent {
+  var components []MotdComponent
+  current := style
+  var run strings.Builder
+  formatting_code := false
+  for _, character := range text {
+    if !formatting_code {
+      if character == '§' {
+        formatting_code = true
+      } else {
+        run.WriteRune(character)
+      }
+      continue
+    }
+    formatting_code = false
+    if run.Len() > 0 {
+      current.Text = run.String()
+      components = append(components, current)
+      run.Reset()
+    }
+    code := character
+    if code >= 'A' && code <= 'Z' {
+      code += 'a' - 'A'
+    }
+    if color, ok := legacy_colors[code]; ok {
+      current = MotdComponent{Color: color}
+      continue
+    }
+    switch code {
+    case 'k':
+      current.Obfuscated = true
+    case 'l':
+      current.Bold = true
+    case 'm':
+      current.Strikethrough = true
+    case 'n':
+      current.Underline = true
+    case 'o':
+      current.Italic = true
+    case 'r':
+      current = MotdComponent{}
+    }
+  }
+  if run.Len() > 0 {
+    current.Text = run.String()
+    components = append(components, current)
+  }
+  return components
+}
@@ -0,0 +1,34 @@
+/* Unit tests for motd.go */
+
+package minestat
+
+import "encoding/json"
+import "reflect"
+import "testing"
+
+func TestLegacyComponents(t *testing.T) {
+  components := legacy_components("§6§lGold §rplain §cred§ntext", MotdComponent{})
+  want := []MotdComponent{
+    {Text: "Gold ", Color: "gold", Bold: true},
+    {Text: "plain "},
+    {Text: "red", Color: "red"},
+    {Text: "text", Color: "red", Underline: true},
+  }
+  if !reflect.DeepEqual(components, want) {
+    t.Errorf("got %+v, want %+v", components, want)
+  }
+}
+
+func TestChatComponents(t *testing.T) {
+  var text chat_text
+  json.Unmarshal([]byte(`{"text": "A ", "color": "gold", "extra": [{"text": "Bold", "bold": true}, " §9Server"]}`), &text)
+  want := []MotdComponent{
+    {Text: "A ", Color: "gold"},
+    {Text: "Bold", Color: "gold", Bold: true},
+    {Text: " ", Color: "gold"},
+    {Text: "Server", Color: "blue"},
+  }
+  if !reflect.DeepEqual(text.components, want) {
+    t.Errorf("got %+v, want %+v", text.components, want)
+  }
+}
@@ -0,0 +1,110 @@
+/*
+ * packet.go - VarInt packet framing of the 1.7+ protocol
+ * Copyright (C) 2016 Lloyd Dilley
+ * http://www.dilley.me/
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package minestat
+
+import "bytes"
+import "errors"
+import "fmt"
+import "io"
+
+/* Packets of the 1.7+ protocol are framed as:
+   1. packet length (packet ID and payload) as a VarInt
+   2. packet ID as a VarInt
+   3. payload
+   VarInts hold 7 bits per byte, least significant group first, with the high bit set
+   on every byte but the last. These helpers are exported for reuse, e.g. to talk to a
+   server over a connection of one's own. */
+
+// Returned (wrapped) by ReadPacket() for a packet longer than the limit given
+var ErrPacketTooLarge = errors.New("packet too large")
+
+// Source of packets, such as a *bufio.Reader around a connection
+type PacketReader interface {
+  io.Reader
+  io.ByteReader
+}
+
+// Reads a VarInt (up to 5 bytes, least significant group first) as used by the 1.7+ protocol.
+func ReadVarInt(reader io.ByteReader) (int, error) {
+  value := uint32(0)
+  for i := 0; i < 5; i++ {
+    current_byte, err := reader.ReadByte()
+    if err != nil {
+      return 0, err
+    }
+    value |= uint32(current_byte & 0x7F) << (7 * uint(i))
+    if current_byte & 0x80 == 0 {
+      return int(int32(value)), nil
+    }
+  }
+  return 0, errors.New("VarInt is too big")
+}
+
+// Writes a VarInt as used by the 1.7+ protocol. Negative values are encoded as 5 bytes (two's complement).
+func WriteVarInt(writer io.Writer, value int) error {
+  _, err := writer.Write(append_varint(nil, value))
+  return err
+}
+
+func append_varint(data []byte, value int) []byte {
+  unsigned_value := uint32(value)
+  for unsigned_value & ^uint32(0x7F) != 0 {
+    data = append(data, byte(unsigned_value & 0x7F) | 0x80)
+    unsigned_value >>= 7
+  }
+  return append(data, byte(unsigned_value))
+}
+
+/* Reads a whole packet and returns its ID and payload. Packets longer than max_length
+   fail with ErrPacketTooLarge before anything is allocated for them. */
+func ReadPacket(reader PacketReader, max_length int) (packet_id int, payload []byte, err error) {
+  length, err := ReadVarInt(reader)
+  if err != nil {
+    return 0, nil, err
+  }
+  if length > max_length {
+    return 0, nil, fmt.Errorf("%w: %d bytes exceed the limit of %d bytes", ErrPacketTooLarge, length, max_length)
+  }
+  if length < 1 {
+    return 0, nil, fmt.Errorf("invalid packet length %d", length)
+  }
+  packet := make([]byte, length)
+  _, err = io.ReadFull(reader, packet)
+  if err != nil {
+    return 0, nil, err
+  }
+  packet_reader := bytes.NewReader(packet)
+  packet_id, err = ReadVarInt(packet_reader)
+  if err != nil {
+    return 0, nil, fmt.Errorf("invalid packet ID: %w", err)
+  }
+  return packet_id, packet[len(packet) - packet_reader.Len():], nil
+}
+
+// Writes a packet with its length and ID in a single write, since some servers expect it in one segment.
+func WritePacket(writer io.Writer, packet_id int, payload []byte) error {
+  id := append_varint(nil, packet_id)
+  packet := append_varint(nil, len(id) + len(payload))
+  packet = append(packet, id...)
+  packet = append(packet, payload...)
+  _, err := writer.Write(packet)
+  return err
+}
@@ -0,0 +1,44 @@
+/* Unit tests for packet.go */
+
+package minestat
+
+import "bufio"
+import "bytes"
+import "errors"
+import "testing"
+
+func TestVarInt(t *testing.T) {
+  tests := map[int]string{0: "\x00", 1: "\x01", 127: "\x7f", 128: "\x80\x01", 300: "\xac\x02", -1: "\xff\xff\xff\xff\x0f"}
+  for value, encoded := range tests {
+    var buffer bytes.Buffer
+    WriteVarInt(&buffer, value)
+    if buffer.String() != encoded {
+      t.Errorf("WriteVarInt(%d) wrote %q, want %q", value, buffer.String(), encoded)
+    }
+    decoded, err := ReadVarInt(&buffer)
+    if err != nil || decoded != value {
+      t.Errorf("ReadVarInt(%q) = (%d, %v), want %d", encoded, decoded, err, value)
+    }
+  }
+  if _, err := ReadVarInt(bytes.NewReader([]byte("\xff\xff\xff\xff\xff\x01"))); err == nil {
+    t.Error("got no error for a VarInt of 6 bytes")
+  }
+}
+
+func TestPacket(t *testing.T) {
+  var buffer bytes.Buffer
+  WritePacket(&buffer, 0x01, []byte("payload"))
+  if buffer.String() != "\x08\x01payload" {
+    t.Errorf("WritePacket() wrote %q", buffer.String())
+  }
+  packet_id, payload, err := ReadPacket(bufio.NewReader(&buffer), 16)
+  if err != nil || packet_id != 0x01 || string(payload) != "payload" {
+    t.Errorf("ReadPacket() = (%d, %q, %v), want packet 1 with the payload", packet_id, payload, err)
+  }
+
+  WritePacket(&buffer, 0x00, make([]byte, 32))
+  _, _, err = ReadPacket(bufio.NewReader(&buffer), 16)
+  if !errors.Is(err, ErrPacketTooLarge) {
+    t.Errorf("got %v, want ErrPacketTooLarge", err)
+  }
+}
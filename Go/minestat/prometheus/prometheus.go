@@ -0,0 +1,140 @@
+/*
+ * prometheus.go - Query results in the Prometheus text format
+ * Copyright (C) 2016 Lloyd Dilley
+ * http://www.dilley.me/
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+/* Package prometheus exports query results as Prometheus metrics. It writes the text
+   exposition format itself, so that neither it nor minestat depends on the Prometheus
+   client library:
+
+     exporter := prometheus.NewExporter()
+     go func() {
+       for {
+         exporter.Query("mc.example.com")
+         time.Sleep(time.Minute)
+       }
+     }()
+     http.Handle("/metrics", exporter)
+*/
+package prometheus
+
+import "fmt"
+import "io"
+import "net"
+import "net/http"
+import "sort"
+import "strconv"
+import "strings"
+import "sync"
+
+import "github.com/FragLand/minestat/Go/minestat"
+
+// Latest query result of each server, served as metrics with an "address" label
+type Exporter struct {
+  lock sync.Mutex
+  servers map[string]*minestat.ServerStatus
+}
+
+func NewExporter() *Exporter {
+  return &Exporter{servers: make(map[string]*minestat.ServerStatus)}
+}
+
+// Queries the server and keeps the result for the next scrape.
+func (exporter *Exporter) Query(address string, opts ...minestat.Option) (*minestat.ServerStatus, error) {
+  server := minestat.NewServer(address, opts...)
+  err := server.Query()
+  exporter.Observe(server)
+  return server, err
+}
+
+// Keeps the result of a query made elsewhere for the next scrape, replacing the previous one of the same address and port.
+func (exporter *Exporter) Observe(server *minestat.ServerStatus) {
+  address := net.JoinHostPort(server.Address, strconv.Itoa(int(server.Port)))
+  exporter.lock.Lock()
+  exporter.servers[address] = server
+  exporter.lock.Unlock()
+}
+
+// Forgets the server at address ("host:port"), e.g. when it is no longer monitored.
+func (exporter *Exporter) Remove(address string) {
+  exporter.lock.Lock()
+  delete(exporter.servers, address)
+  exporter.lock.Unlock()
+}
+
+// Serves the metrics to a Prometheus scrape.
+func (exporter *Exporter) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+  writer.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+  exporter.WriteTo(writer)
+}
+
+/* Writes the metrics in the Prometheus text format. Offline servers only have
+   minecraft_server_online, since their player counts and latency are unknown. */
+func (exporter *Exporter) WriteTo(writer io.Writer) (int64, error) {
+  exporter.lock.Lock()
+  addresses := make([]string, 0, len(exporter.servers))
+  for address := range exporter.servers {
+    addresses = append(addresses, address)
+  }
+  sort.Strings(addresses)
+  servers := make([]*minestat.ServerStatus, len(addresses))
+  for i, address := range addresses {
+    servers[i] = exporter.servers[address]
+  }
+  exporter.lock.Unlock()
+
+  metrics := []struct {
+    name string
+    help string
+    value func(server *minestat.ServerStatus) float64
+    online_only bool
+  }{
+    {"minecraft_server_online", "Whether the server answered the last query (1) or not (0).", func(server *minestat.ServerStatus) float64 {
+      if server.Online {
+        return 1
+      }
+      return 0
+    }, false},
+    {"minecraft_server_players_online", "Number of players online.", func(server *minestat.ServerStatus) float64 {
+      return float64(server.Players.Online)
+    }, true},
+    {"minecraft_server_players_max", "Maximum number of players.", func(server *minestat.ServerStatus) float64 {
+      return float64(server.Players.Max)
+    }, true},
+    {"minecraft_server_latency_ms", "Latency of the last query in milliseconds.", func(server *minestat.ServerStatus) float64 {
+      return float64(server.Latency.Microseconds()) / 1000
+    }, true},
+  }
+  var text strings.Builder
+  for _, metric := range metrics {
+    fmt.Fprintf(&text, "# HELP %s %s\n# TYPE %s gauge\n", metric.name, metric.help, metric.name)
+    for i, server := range servers {
+      if metric.online_only && !server.Online {
+        continue
+      }
+      fmt.Fprintf(&text, "%s{address=\"%s\"} %s\n", metric.name, escape_label(addresses[i]), strconv.FormatFloat(metric.value(server), 'g', -1, 64))
+    }
+  }
+  written, err := io.WriteString(writer, text.String())
+  return int64(written), err
+}
+
+// Escapes a label value as the text format requires.
+func escape_label(value string) string {
+  return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
@@ -0,0 +1,36 @@
+/* Unit tests for prometheus.go */
+
+package prometheus
+
+import "strings"
+import "testing"
+import "time"
+
+import "github.com/FragLand/minestat/Go/minestat"
+
+func TestWriteTo(t *testing.T) {
+  exporter := NewExporter()
+  online := minestat.NewServer("mc.example.com")
+  online.Online = true
+  online.Players = minestat.PlayerInfo{Online: 3, Max: 20}
+  online.Latency = 42 * time.Millisecond
+  exporter.Observe(online)
+  exporter.Observe(minestat.NewServer("down.example.com", minestat.WithPort(25566)))
+
+  var text strings.Builder
+  exporter.WriteTo(&text)
+  for _, line := range []string{
+    `minecraft_server_online{address="down.example.com:25566"} 0`,
+    `minecraft_server_online{address="mc.example.com:25565"} 1`,
+    `minecraft_server_players_online{address="mc.example.com:25565"} 3`,
+    `minecraft_server_players_max{address="mc.example.com:25565"} 20`,
+    `minecraft_server_latency_ms{address="mc.example.com:25565"} 42`,
+  } {
+    if !strings.Contains(text.String(), line + "\n") {
+      t.Errorf("missing %q in:\n%s", line, text.String())
+    }
+  }
+  if strings.Contains(text.String(), `minecraft_server_players_online{address="down.example.com:25566"}`) {
+    t.Errorf("got player counts for an offline server:\n%s", text.String())
+  }
+}
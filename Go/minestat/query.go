@@ -0,0 +1,230 @@
+/*
+ * query.go - Minecraft Query (GameSpy 4) protocol support
+ * Copyright (C) 2016 Lloyd Dilley
+ * http://www.dilley.me/
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package minestat
+
+import "bytes"
+import "context"
+import "encoding/binary"
+import "errors"
+import "fmt"
+import "net"
+import "strconv"
+import "strings"
+import "sync"
+import "time"
+
+const QUERY_SESSION_ID int32 = 0x01010101 // only the lower 4 bits of each byte are used
+const QUERY_CHALLENGE_TTL = 25 * time.Second // vanilla servers drop challenge tokens after 30 to 60 seconds
+
+/*
+  Query (GameSpy 4) protocol, only answered when enable-query=true is set in server.properties
+  https://wiki.vg/Query
+  1. Client sends a handshake:
+    a. 0xFE 0xFD (magic)
+    b. 0x09 (handshake)
+    c. session ID as an int
+  2. Server responds with 0x09, the session ID and a challenge token as a NUL-terminated decimal string
+  3. Client sends a full stat request:
+    a. 0xFE 0xFD (magic)
+    b. 0x00 (stat)
+    c. session ID as an int
+    d. challenge token as an int
+    e. 0x00 0x00 0x00 0x00 (padding, requests the full stat)
+  4. Server responds with:
+    a. 0x00 and the session ID
+    b. "splitnum" 0x00 0x80 0x00 (padding)
+    c. NUL-terminated key/value pairs, ending with an empty key
+    d. 0x01 "player_" 0x00 0x00 (padding)
+    e. NUL-terminated player names, ending with an empty name
+*/
+func (server *ServerStatus) query_request(ctx context.Context) (Status_code, error) {
+  conn, retval, err := server.dial(ctx, "udp", server.dial_address, server.dial_port)
+  if retval != RETURN_SUCCESS {
+    return retval, err
+  }
+  defer conn.Close()
+
+  key := conn.RemoteAddr().String()
+  challenge_token, cached := cached_challenge(key)
+  if !cached {
+    challenge_token, retval, err = server.query_handshake(ctx, conn)
+    if retval != RETURN_SUCCESS {
+      return retval, err
+    }
+  }
+
+  response, retval, err := server.query_full_stat(ctx, conn, challenge_token)
+  // Servers silently drop requests with an expired token, so get a new one once.
+  if retval == RETURN_TIMEOUT && cached && ctx.Err() == nil {
+    forget_challenge(key)
+    challenge_token, retval, err = server.query_handshake(ctx, conn)
+    if retval != RETURN_SUCCESS {
+      return retval, err
+    }
+    response, retval, err = server.query_full_stat(ctx, conn, challenge_token)
+  }
+  if retval != RETURN_SUCCESS {
+    forget_challenge(key)
+    return retval, err
+  }
+
+  retval, err = server.parse_query(response)
+  if retval == RETURN_SUCCESS {
+    cache_challenge(key, challenge_token)
+  } else {
+    forget_challenge(key)
+  }
+  return retval, err
+}
+
+// Sends the handshake and returns the challenge token.
+func (server *ServerStatus) query_handshake(ctx context.Context, conn net.Conn) (uint32, Status_code, error) {
+  var handshake bytes.Buffer
+  handshake.Write([]byte("\xFE\xFD\x09"))
+  binary.Write(&handshake, binary.BigEndian, QUERY_SESSION_ID)
+  _, err := conn.Write(handshake.Bytes())
+  if err != nil {
+    retval, err := io_error(err)
+    return 0, retval, err
+  }
+  response, retval, err := server.read_datagram(ctx, conn, 64)
+  if retval != RETURN_SUCCESS {
+    return 0, retval, err
+  }
+  if len(response) < 6 || response[0] != 0x09 {
+    return 0, RETURN_UNKNOWN, errors.New("invalid handshake response")
+  }
+  challenge_token, err := strconv.ParseInt(strings.TrimRight(string(response[5:]), "\x00"), 10, 64)
+  if err != nil {
+    return 0, RETURN_UNKNOWN, fmt.Errorf("invalid challenge token: %w", err)
+  }
+  return uint32(challenge_token), RETURN_SUCCESS, nil
+}
+
+// Sends the full stat request and returns the response.
+func (server *ServerStatus) query_full_stat(ctx context.Context, conn net.Conn, challenge_token uint32) ([]byte, Status_code, error) {
+  var request bytes.Buffer
+  request.Write([]byte("\xFE\xFD\x00"))
+  binary.Write(&request, binary.BigEndian, QUERY_SESSION_ID)
+  binary.Write(&request, binary.BigEndian, challenge_token)
+  request.Write([]byte("\x00\x00\x00\x00"))
+  _, err := conn.Write(request.Bytes())
+  if err != nil {
+    retval, err := io_error(err)
+    return nil, retval, err
+  }
+  return server.read_datagram(ctx, conn, 65535)
+}
+
+/* Challenge tokens by server address. Vanilla servers hand out one token per client IP,
+   so a token stays valid when the next query comes from a new local port. A server that
+   rejects it anyway gets a new handshake once the full stat request times out. */
+type query_challenge struct {
+  token uint32
+  issued time.Time
+}
+
+var query_challenges = make(map[string]query_challenge)
+var query_challenges_lock sync.Mutex
+
+func cached_challenge(key string) (uint32, bool) {
+  query_challenges_lock.Lock()
+  defer query_challenges_lock.Unlock()
+  challenge, ok := query_challenges[key]
+  if !ok || time.Since(challenge.issued) >= QUERY_CHALLENGE_TTL {
+    delete(query_challenges, key)
+    return 0, false
+  }
+  return challenge.token, true
+}
+
+func cache_challenge(key string, token uint32) {
+  query_challenges_lock.Lock()
+  defer query_challenges_lock.Unlock()
+  // Keep the time the token was first handed out, since that is when it expires.
+  if challenge, ok := query_challenges[key]; !ok || challenge.token != token {
+    query_challenges[key] = query_challenge{token, time.Now()}
+  }
+  // Servers that are not queried again would keep their token forever, so drop the expired ones here.
+  for other, challenge := range query_challenges {
+    if time.Since(challenge.issued) >= QUERY_CHALLENGE_TTL {
+      delete(query_challenges, other)
+    }
+  }
+}
+
+func forget_challenge(key string) {
+  query_challenges_lock.Lock()
+  delete(query_challenges, key)
+  query_challenges_lock.Unlock()
+}
+
+// Parses a full stat response.
+func (server *ServerStatus) parse_query(response []byte) (Status_code, error) {
+  // type (1) + session ID (4) + "splitnum\x00\x80\x00" padding (11)
+  if len(response) < 16 || response[0] != 0x00 {
+    return RETURN_UNKNOWN, errors.New("invalid full stat response")
+  }
+  player_section := bytes.Index(response, []byte("\x00\x00\x01player_\x00\x00"))
+  if player_section < 16 {
+    return RETURN_UNKNOWN, errors.New("full stat response without player section")
+  }
+
+  values := make(map[string]string)
+  data := strings.Split(string(response[16:player_section]), "\x00")
+  if server.Capture_fields {
+    server.Fields = data
+  }
+  for i := 0; i + 1 < len(data); i += 2 {
+    values[data[i]] = data[i + 1]
+  }
+  current_players, err := parse_player_count(values["numplayers"])
+  if err != nil {
+    return RETURN_UNKNOWN, server.field_error("current_players", err)
+  }
+  max_players, err := parse_player_count(values["maxplayers"])
+  if err != nil {
+    return RETURN_UNKNOWN, server.field_error("max_players", err)
+  }
+
+  server.Protocol = "Query (GameSpy 4)"
+  server.Motd = values["hostname"]
+  server.Version = values["version"]
+  server.Map = values["map"]
+  server.Players.Online = current_players
+  server.Players.Max = max_players
+  // "<server software>: <plugin>; <plugin>", empty on vanilla servers
+  plugins := values["plugins"]
+  if colon := strings.Index(plugins, ":"); colon >= 0 {
+    for _, plugin := range strings.Split(plugins[colon + 1:], ";") {
+      if plugin = strings.TrimSpace(plugin); plugin != "" {
+        server.Plugins = append(server.Plugins, plugin)
+      }
+    }
+  }
+  server.Player_list = []string{}
+  for _, player := range strings.Split(string(response[player_section + 12:]), "\x00") {
+    if player != "" {
+      server.Player_list = append(server.Player_list, player)
+    }
+  }
+  return RETURN_SUCCESS, nil
+}
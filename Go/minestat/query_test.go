@@ -0,0 +1,166 @@
+/* Unit tests for query.go */
+
+package minestat
+
+import "bytes"
+import "context"
+import "io"
+import "net"
+import "sync"
+import "testing"
+
+// Full stat response of a CraftBukkit 1.5.2 server with two players online
+const FULL_STAT_RESPONSE = "\x00\x01\x01\x01\x01splitnum\x00\x80\x00" +
+  "hostname\x00A Minecraft Server\x00gametype\x00SMP\x00game_id\x00MINECRAFT\x00version\x001.5.2\x00" +
+  "plugins\x00CraftBukkit on Bukkit 1.5.2-R0.1: WorldEdit 5.5.6; Essentials 2.9.2\x00" +
+  "map\x00world\x00numplayers\x002\x00maxplayers\x0020\x00hostport\x0025565\x00hostip\x00127.0.0.1\x00" +
+  "\x00\x01player_\x00\x00Notch\x00jeb_\x00\x00"
+
+func TestParseQuery(t *testing.T) {
+  server := NewServer("127.0.0.1")
+  retval, err := server.parse_query([]byte(FULL_STAT_RESPONSE))
+  if retval != RETURN_SUCCESS {
+    t.Fatalf("got %s (%v), want success", retval, err)
+  }
+  if server.Motd != "A Minecraft Server" || server.Version != "1.5.2" || server.Map != "world" {
+    t.Errorf("got MOTD %q, version %q and map %q", server.Motd, server.Version, server.Map)
+  }
+  if server.Players.Online != 2 || server.Players.Max != 20 {
+    t.Errorf("got %d/%d players, want 2/20", server.Players.Online, server.Players.Max)
+  }
+  if len(server.Plugins) != 2 || server.Plugins[0] != "WorldEdit 5.5.6" || server.Plugins[1] != "Essentials 2.9.2" {
+    t.Errorf("got plugins %q", server.Plugins)
+  }
+  if len(server.Player_list) != 2 || server.Player_list[0] != "Notch" || server.Player_list[1] != "jeb_" {
+    t.Errorf("got players %q", server.Player_list)
+  }
+}
+
+func TestParseQueryVanilla(t *testing.T) {
+  response := bytes.Replace([]byte(FULL_STAT_RESPONSE), []byte("CraftBukkit on Bukkit 1.5.2-R0.1: WorldEdit 5.5.6; Essentials 2.9.2"), nil, 1)
+  response = bytes.Replace(response, []byte("Notch\x00jeb_\x00"), nil, 1)
+  server := NewServer("127.0.0.1")
+  retval, err := server.parse_query(response)
+  if retval != RETURN_SUCCESS || server.Plugins != nil || server.Player_list == nil || len(server.Player_list) != 0 {
+    t.Errorf("got %s (%v) with plugins %q and players %q, want neither", retval, err, server.Plugins, server.Player_list)
+  }
+}
+
+func TestParseQueryMalformed(t *testing.T) {
+  tests := map[string]string{
+    "empty": "",
+    "short": FULL_STAT_RESPONSE[:10],
+    "wrong type": "\x09" + FULL_STAT_RESPONSE[1:],
+    "no player section": FULL_STAT_RESPONSE[:bytes.Index([]byte(FULL_STAT_RESPONSE), []byte("\x01player_"))],
+    "invalid player count": string(bytes.Replace([]byte(FULL_STAT_RESPONSE), []byte("numplayers\x002"), []byte("numplayers\x00two"), 1)),
+  }
+  for name, response := range tests {
+    server := NewServer("127.0.0.1")
+    if retval, _ := server.parse_query([]byte(response)); retval != RETURN_UNKNOWN {
+      t.Errorf("%s: got %s, want %s", name, retval, RETURN_UNKNOWN)
+    }
+  }
+}
+
+// Answers a Query handshake on conn with the given response and returns the request.
+func serve_query_handshake(conn net.Conn, response string) []byte {
+  defer conn.Close()
+  request := make([]byte, 7)
+  io.ReadFull(conn, request)
+  conn.Write([]byte(response))
+  return request
+}
+
+func TestQueryHandshake(t *testing.T) {
+  tests := map[string]uint32{
+    "\x09\x01\x01\x01\x019513307\x00": 9513307,
+    "\x09\x01\x01\x01\x01-1234567\x00": 0xFFED2979,
+  }
+  for response, want := range tests {
+    client, server_conn := net.Pipe()
+    requests := make(chan []byte, 1)
+    go func() {
+      requests <- serve_query_handshake(server_conn, response)
+    }()
+    server := NewServer("127.0.0.1")
+    token, retval, err := server.query_handshake(context.Background(), client)
+    client.Close()
+    if retval != RETURN_SUCCESS || token != want {
+      t.Errorf("got token %d (%v) from %q, want %d", token, err, response, want)
+    }
+    if request := <-requests; string(request) != "\xFE\xFD\x09\x01\x01\x01\x01" {
+      t.Errorf("sent handshake %q", request)
+    }
+  }
+}
+
+func TestQueryHandshakeMalformed(t *testing.T) {
+  for _, response := range []string{"\x09\x01\x01", "\x00\x01\x01\x01\x019513307\x00", "\x09\x01\x01\x01\x01token\x00"} {
+    client, server_conn := net.Pipe()
+    go serve_query_handshake(server_conn, response)
+    server := NewServer("127.0.0.1")
+    _, retval, _ := server.query_handshake(context.Background(), client)
+    client.Close()
+    if retval != RETURN_UNKNOWN {
+      t.Errorf("got %s from %q, want %s", retval, response, RETURN_UNKNOWN)
+    }
+  }
+}
+
+// Connection with the given addresses, e.g. to tell apart the ends of net.Pipe().
+type addressed_conn struct {
+  net.Conn
+  local net.Addr
+  remote net.Addr
+}
+
+func (conn *addressed_conn) LocalAddr() net.Addr {
+  return conn.local
+}
+
+func (conn *addressed_conn) RemoteAddr() net.Addr {
+  return conn.remote
+}
+
+func TestQueryReusesChallengeToken(t *testing.T) {
+  defer forget_challenge("127.0.0.1:25566")
+  var lock sync.Mutex
+  handshakes := 0
+  local_port := 50000
+  dial_func := func(ctx context.Context, network string, address string) (net.Conn, error) {
+    client, server_conn := net.Pipe()
+    // A new local port for each query, as each new UDP socket gets one
+    local_port++
+    client_conn := &addressed_conn{client, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: local_port}, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 25566}}
+    go func() {
+      defer server_conn.Close()
+      request := make([]byte, 64)
+      for {
+        length, err := server_conn.Read(request)
+        if err != nil {
+          return
+        }
+        if length == 7 && request[2] == 0x09 {
+          lock.Lock()
+          handshakes++
+          lock.Unlock()
+          server_conn.Write([]byte("\x09\x01\x01\x01\x019513307\x00"))
+        } else {
+          server_conn.Write([]byte(FULL_STAT_RESPONSE))
+        }
+      }
+    }()
+    return client_conn, nil
+  }
+  for i := 0; i < 2; i++ {
+    server := NewServer("127.0.0.1", WithPort(25566), WithProtocol(REQUEST_QUERY), WithDialFunc(dial_func))
+    if err := server.Query(); err != nil {
+      t.Fatalf("query %d: %v", i + 1, err)
+    }
+  }
+  lock.Lock()
+  defer lock.Unlock()
+  if handshakes != 1 {
+    t.Errorf("got %d handshakes over two queries, want 1", handshakes)
+  }
+}
@@ -0,0 +1,100 @@
+/*
+ * rcon.go - RCON liveness check
+ * Copyright (C) 2016 Lloyd Dilley
+ * http://www.dilley.me/
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package minestat
+
+import "bytes"
+import "encoding/binary"
+import "errors"
+import "fmt"
+import "io"
+import "net"
+import "strconv"
+import "time"
+
+const RCON_REQUEST_ID int32 = 0x4D53 // arbitrary, echoed back on success
+
+/*
+  RCON authentication, only answered when enable-rcon=true is set in server.properties
+  https://wiki.vg/RCON
+  1. Client sends a login packet:
+    a. remaining packet length as a little-endian int
+    b. request ID as a little-endian int
+    c. 3 (login) as a little-endian int
+    d. password as a NUL-terminated ASCII string
+    e. 0x00 (padding)
+  2. Server responds with a packet of type 2 (auth response) carrying the same
+     request ID on success or -1 on a wrong password
+  Returns nil if the password is accepted. No command is run.
+*/
+func RconPing(address string, port uint16, password string) error {
+  timeout := time.Duration(DEFAULT_TIMEOUT) * time.Second
+  conn, err := net.DialTimeout("tcp", net.JoinHostPort(address, strconv.Itoa(int(port))), timeout)
+  if err != nil {
+    _, err = connect_error(err)
+    return err
+  }
+  defer conn.Close()
+  conn.SetDeadline(time.Now().Add(timeout))
+
+  var login bytes.Buffer
+  binary.Write(&login, binary.LittleEndian, int32(4 + 4 + len(password) + 2))
+  binary.Write(&login, binary.LittleEndian, RCON_REQUEST_ID)
+  binary.Write(&login, binary.LittleEndian, int32(3))
+  login.WriteString(password)
+  login.Write([]byte("\x00\x00"))
+  _, err = conn.Write(login.Bytes())
+  if err != nil {
+    _, err = io_error(err)
+    return err
+  }
+
+  // Some implementations send an empty response value packet (type 0) before the auth response.
+  for {
+    var header struct {
+      Length int32
+      Request_id int32
+      Type int32
+    }
+    err = binary.Read(conn, binary.LittleEndian, &header)
+    if err != nil {
+      _, err = io_error(err)
+      return err
+    }
+    if header.Length < 10 || header.Length > 4096 {
+      return fmt.Errorf("invalid RCON packet length %d", header.Length)
+    }
+    _, err = io.CopyN(io.Discard, conn, int64(header.Length - 8))
+    if err != nil {
+      _, err = io_error(err)
+      return err
+    }
+    if header.Type != 2 {
+      continue
+    }
+    if header.Request_id == -1 {
+      return errors.New("RCON authentication failed")
+    }
+    if header.Request_id != RCON_REQUEST_ID {
+      return fmt.Errorf("unexpected RCON request ID %d", header.Request_id)
+    }
+    return nil
+  }
+}
@@ -0,0 +1,84 @@
+/* Unit tests for rcon.go */
+
+package minestat
+
+import "bytes"
+import "encoding/binary"
+import "io"
+import "net"
+import "strings"
+import "testing"
+
+// Packs an RCON packet with the given length, which is normally that of the rest of the packet.
+func rcon_packet(length int32, request_id int32, packet_type int32) []byte {
+  var packet bytes.Buffer
+  binary.Write(&packet, binary.LittleEndian, length)
+  binary.Write(&packet, binary.LittleEndian, request_id)
+  binary.Write(&packet, binary.LittleEndian, packet_type)
+  packet.Write([]byte("\x00\x00"))
+  return packet.Bytes()
+}
+
+/* Listens on a local port, reads the login packet of one connection and answers it with
+   the given packets. Returns the port and the login packet once read. */
+func serve_rcon(t *testing.T, packets ...[]byte) (uint16, chan []byte) {
+  listener, err := net.Listen("tcp", "127.0.0.1:0")
+  if err != nil {
+    t.Fatal(err)
+  }
+  logins := make(chan []byte, 1)
+  go func() {
+    defer listener.Close()
+    conn, err := listener.Accept()
+    if err != nil {
+      close(logins)
+      return
+    }
+    defer conn.Close()
+    var length int32
+    binary.Read(conn, binary.LittleEndian, &length)
+    login := make([]byte, length)
+    io.ReadFull(conn, login)
+    logins <- login
+    for _, packet := range packets {
+      conn.Write(packet)
+    }
+  }()
+  return uint16(listener.Addr().(*net.TCPAddr).Port), logins
+}
+
+func TestRconPing(t *testing.T) {
+  port, logins := serve_rcon(t, rcon_packet(10, RCON_REQUEST_ID, 2))
+  if err := RconPing("127.0.0.1", port, "hunter2"); err != nil {
+    t.Errorf("got %v, want the password accepted", err)
+  }
+  // request ID, type 3 (login), password and padding
+  if login := <-logins; string(login) != "\x53\x4D\x00\x00\x03\x00\x00\x00hunter2\x00\x00" {
+    t.Errorf("sent login %q", login)
+  }
+}
+
+func TestRconPingWrongPassword(t *testing.T) {
+  port, _ := serve_rcon(t, rcon_packet(10, -1, 2))
+  err := RconPing("127.0.0.1", port, "wrong")
+  if err == nil || !strings.Contains(err.Error(), "authentication failed") {
+    t.Errorf("got %v, want the authentication to fail", err)
+  }
+}
+
+func TestRconPingLeadingResponseValue(t *testing.T) {
+  port, _ := serve_rcon(t, rcon_packet(10, RCON_REQUEST_ID, 0), rcon_packet(10, RCON_REQUEST_ID, 2))
+  if err := RconPing("127.0.0.1", port, "hunter2"); err != nil {
+    t.Errorf("got %v after an empty response value packet, want the password accepted", err)
+  }
+}
+
+func TestRconPingInvalidLength(t *testing.T) {
+  for _, length := range []int32{9, 4097, -1} {
+    port, _ := serve_rcon(t, rcon_packet(length, RCON_REQUEST_ID, 2))
+    err := RconPing("127.0.0.1", port, "hunter2")
+    if err == nil || !strings.Contains(err.Error(), "invalid RCON packet length") {
+      t.Errorf("got %v for a length of %d, want it rejected", err, length)
+    }
+  }
+}
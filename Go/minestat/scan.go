@@ -0,0 +1,103 @@
+/*
+ * scan.go - Streaming scans of address lists
+ * Copyright (C) 2016 Lloyd Dilley
+ * http://www.dilley.me/
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package minestat
+
+import "bufio"
+import "encoding/json"
+import "io"
+import "strings"
+import "sync"
+
+// Line written by ScanReader() for an address that could not be parsed
+type scan_error struct {
+  Address string `json:"address"`
+  Online bool `json:"online"`
+  Connection_status Status_code `json:"connection_status"`
+  Error string `json:"error"`
+}
+
+/* Reads one "host" or "host:port" address per line from r, queries each with at most
+   concurrency queries running at a time and writes each result to w as a line of JSON,
+   in the order the queries finish. Blank lines and lines starting with "#" are skipped.
+   A malformed address yields a line with an "error" instead of stopping the scan, only
+   failing to read r or write w does. Addresses without a port look up SRV records. */
+func ScanReader(r io.Reader, w io.Writer, concurrency int, opts ...Option) error {
+  if concurrency < 1 {
+    concurrency = 1
+  }
+  var write_lock sync.Mutex
+  var write_err error
+  write := func(line []byte) {
+    write_lock.Lock()
+    defer write_lock.Unlock()
+    if write_err == nil {
+      _, write_err = w.Write(append(line, '\n'))
+    }
+  }
+  failed := func() bool {
+    write_lock.Lock()
+    defer write_lock.Unlock()
+    return write_err != nil
+  }
+
+  lines := make(chan string)
+  var workers sync.WaitGroup
+  for worker := 0; worker < concurrency; worker++ {
+    workers.Add(1)
+    go func() {
+      defer workers.Done()
+      for line := range lines {
+        write(scan_line(line, opts))
+      }
+    }()
+  }
+  scanner := bufio.NewScanner(r)
+  for scanner.Scan() && !failed() {
+    line := strings.TrimSpace(scanner.Text())
+    if line == "" || strings.HasPrefix(line, "#") {
+      continue
+    }
+    lines <- line
+  }
+  close(lines)
+  workers.Wait()
+  if err := scanner.Err(); err != nil {
+    return err
+  }
+  return write_err
+}
+
+// Queries the address on a line of ScanReader() input and returns its JSON result.
+func scan_line(line string, opts []Option) []byte {
+  host, port, port_given, err := split_address(line)
+  if err != nil {
+    result, _ := json.Marshal(scan_error{line, false, RETURN_UNKNOWN, err.Error()})
+    return result
+  }
+  if port_given {
+    // A copy, so that workers do not append to the shared slice.
+    opts = append(opts[:len(opts):len(opts)], WithPort(port))
+  }
+  server := NewServer(host, opts...)
+  server.Query()
+  result, _ := json.Marshal(server)
+  return result
+}
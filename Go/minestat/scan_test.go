@@ -0,0 +1,35 @@
+/* Unit tests for scan.go */
+
+package minestat
+
+import "bytes"
+import "encoding/json"
+import "strings"
+import "testing"
+
+func TestScanReader(t *testing.T) {
+  input := "# servers\n\n127.0.0.1:25566\nnot a host\n"
+  var output bytes.Buffer
+  err := ScanReader(strings.NewReader(input), &output, 2, WithProtocol(REQUEST_JSON), WithDialFunc(status_dial_func(TEST_STATUS)))
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  results := map[string]map[string]interface{}{}
+  for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+    var result map[string]interface{}
+    if err := json.Unmarshal([]byte(line), &result); err != nil {
+      t.Fatalf("got invalid JSON line %q: %v", line, err)
+    }
+    results[result["address"].(string)] = result
+  }
+  if len(results) != 2 {
+    t.Fatalf("got %d results, want 2: %s", len(results), output.String())
+  }
+  if result := results["127.0.0.1"]; result["online"] != true || result["port"] != float64(25566) {
+    t.Errorf("got %v, want 127.0.0.1 online on port 25566", result)
+  }
+  if result := results["not a host"]; result["online"] != false || result["error"] == nil {
+    t.Errorf("got %v, want an error for the malformed address", result)
+  }
+}